@@ -0,0 +1,173 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// archiveFormat returns "tar.gz" or "zip" for an --archive file name
+func archiveFormat(name string) (string, error) {
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return "tar.gz", nil
+	case strings.HasSuffix(lower, ".zip"):
+		return "zip", nil
+	}
+	return "", fmt.Errorf("%s: archive name must end in .tar.gz, .tgz or .zip", name)
+}
+
+// archiveEntry is a file or symlink to pack, named relative to the tree
+type archiveEntry struct {
+	path string
+	name string
+	info fs.FileInfo
+}
+
+// archiveEntries lists what is under dir in lexical order, leaving out
+// the paths in skip
+func archiveEntries(dir string, skip ...string) ([]archiveEntry, error) {
+	skipped := map[string]bool{}
+	for _, p := range skip {
+		if abs, err := filepath.Abs(p); err == nil {
+			skipped[abs] = true
+		}
+	}
+
+	var entries []archiveEntry
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		if abs, err := filepath.Abs(p); err == nil && skipped[abs] {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, archiveEntry{path: p, name: filepath.ToSlash(rel), info: info})
+		return nil
+	})
+	return entries, err
+}
+
+// writeArchive packs the files under dir into dest, a .tar.gz or .zip by
+// its name. Files go in sorted by path, so the same tree always packs
+// the same way. State files and dest itself are left out.
+func writeArchive(dir, dest string, skip ...string) error {
+	format, err := archiveFormat(dest)
+	if err != nil {
+		return err
+	}
+	entries, err := archiveEntries(dir, append(skip, dest)...)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	if format == "zip" {
+		err = writeZip(f, entries)
+	} else {
+		err = writeTarGz(f, entries)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(dest)
+	}
+	return err
+}
+
+func writeTarGz(w io.Writer, entries []archiveEntry) error {
+	zw := gzip.NewWriter(w)
+	tw := tar.NewWriter(zw)
+	for _, e := range entries {
+		var link string
+		if e.info.Mode()&fs.ModeSymlink != 0 {
+			var err error
+			if link, err = os.Readlink(e.path); err != nil {
+				return err
+			}
+		}
+		header, err := tar.FileInfoHeader(e.info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = e.name
+		header.Uname, header.Gname = "", ""
+		header.Uid, header.Gid = 0, 0
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if link == "" {
+			if err := copyFileTo(tw, e.path); err != nil {
+				return err
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func writeZip(w io.Writer, entries []archiveEntry) error {
+	zw := zip.NewWriter(w)
+	for _, e := range entries {
+		header, err := zip.FileInfoHeader(e.info)
+		if err != nil {
+			return err
+		}
+		header.Name = e.name
+		header.Method = zip.Deflate
+		fw, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		// A symlink is stored as its target, as zip tools expect
+		if e.info.Mode()&fs.ModeSymlink != 0 {
+			link, err := os.Readlink(e.path)
+			if err != nil {
+				return err
+			}
+			if _, err := io.WriteString(fw, link); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := copyFileTo(fw, e.path); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// copyFileTo copies the file at p to w
+func copyFileTo(w io.Writer, p string) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
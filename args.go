@@ -0,0 +1,131 @@
+package main
+
+import (
+	"flag"
+	"strings"
+)
+
+// flagAliases maps GNU wget long options and short letters to the flag
+// they stand for
+var flagAliases = map[string]string{
+	"output-document":     "O",
+	"directory-prefix":    "P",
+	"background":          "B",
+	"limit-rate":          "rate-limit",
+	"input-file":          "i",
+	"m":                   "mirror",
+	"r":                   "mirror",
+	"recursive":           "mirror",
+	"reject":              "R",
+	"accept":              "A",
+	"exclude-directories": "X",
+	"include-directories": "I",
+	"k":                   "convert-links",
+	"user-agent":          "U",
+	"tries":               "t",
+	"continue":            "c",
+	"level":               "l",
+	"np":                  "no-parent",
+	"span-hosts":          "H",
+	"p":                   "page-requisites",
+	"execute":             "e",
+	"domains":             "D",
+	"no-host-directories": "nH",
+	"no-directories":      "nd",
+	"adjust-extension":    "E",
+	"html-extension":      "E",
+	"backup-converted":    "K",
+	"timestamping":        "N",
+	"quota":               "Q",
+}
+
+// registerAliases defines every alias in flagAliases on fs, sharing the
+// Value of the flag it stands for
+func registerAliases(fs *flag.FlagSet) {
+	for alias, name := range flagAliases {
+		f := fs.Lookup(name)
+		if f == nil {
+			continue
+		}
+		fs.Var(f.Value, alias, "Same as -"+name)
+	}
+}
+
+// canonicalFlag resolves an alias to the name of the flag it stands for
+func canonicalFlag(name string) string {
+	if canonical, ok := flagAliases[name]; ok {
+		return canonical
+	}
+	return name
+}
+
+// isBoolFlag reports whether f can be given without a value
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// normalizeArgs rewrites a GNU-style command line into one the flag
+// package can parse: clustered short flags are split ("-Bk" becomes
+// "-B -k", "-Odir" becomes "-O dir") and flags given after positional
+// arguments are moved in front of them, so "wget url -P dir" works.
+// Everything after a literal "--" is left positional.
+func normalizeArgs(fs *flag.FlagSet, args []string) []string {
+	var flags, positional []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			positional = append(positional, args[i+1:]...)
+			break
+		}
+		if arg == "-" || !strings.HasPrefix(arg, "-") {
+			positional = append(positional, arg)
+			continue
+		}
+
+		name := strings.TrimLeft(arg, "-")
+		name, _, hasValue := strings.Cut(name, "=")
+		if f := fs.Lookup(name); f != nil || strings.HasPrefix(arg, "--") {
+			// A whole flag name; pull in its value if it is separate
+			flags = append(flags, arg)
+			if f != nil && !hasValue && !isBoolFlag(f) && i+1 < len(args) {
+				i++
+				flags = append(flags, args[i])
+			}
+			continue
+		}
+
+		flags = append(flags, splitShortFlags(fs, arg, args, &i)...)
+	}
+	return append(append(flags, "--"), positional...)
+}
+
+// splitShortFlags expands a cluster of single-letter flags. The first
+// letter that takes a value consumes the rest of the cluster, or the next
+// argument if the cluster ends there.
+func splitShortFlags(fs *flag.FlagSet, arg string, args []string, i *int) []string {
+	var out []string
+	cluster := arg[1:]
+	for j := 0; j < len(cluster); j++ {
+		f := fs.Lookup(cluster[j : j+1])
+		if f == nil {
+			// Leave it to the flag package to report
+			return append(out, "-"+cluster[j:])
+		}
+		if isBoolFlag(f) {
+			out = append(out, "-"+f.Name)
+			continue
+		}
+
+		value := cluster[j+1:]
+		if value == "" {
+			if *i+1 >= len(args) {
+				return append(out, "-"+f.Name)
+			}
+			*i++
+			value = args[*i]
+		}
+		return append(out, "-"+f.Name, value)
+	}
+	return out
+}
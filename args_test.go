@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"testing"
+)
+
+func testFlagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("wget", flag.ContinueOnError)
+	fs.Bool("B", false, "")
+	fs.Bool("mirror", false, "")
+	fs.Bool("convert-links", false, "")
+	fs.String("O", "", "")
+	fs.String("P", "", "")
+	fs.String("rate-limit", "", "")
+	registerAliases(fs)
+	return fs
+}
+
+func TestNormalizeArgs(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-Bk", "url"}, "[-B -k -- url]"},
+		{[]string{"-Odir/file", "url"}, "[-O dir/file -- url]"},
+		{[]string{"-BO", "file", "url"}, "[-B -O file -- url]"},
+		{[]string{"url", "-P", "dir"}, "[-P dir -- url]"},
+		{[]string{"url", "--limit-rate=200k", "-m"}, "[--limit-rate=200k -m -- url]"},
+		{[]string{"--output-document", "file", "url"}, "[--output-document file -- url]"},
+		{[]string{"-B", "--", "-url"}, "[-B -- -url]"},
+		{[]string{"-", "-B"}, "[-B -- -]"},
+		{[]string{"-Bz"}, "[-B -z --]"},
+		{[]string{"-O"}, "[-O --]"},
+	}
+	for _, tt := range tests {
+		got := fmt.Sprint(normalizeArgs(testFlagSet(), tt.args))
+		if got != tt.want {
+			t.Errorf("normalizeArgs(%q) = %s, want %s", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestAliasesShareValues(t *testing.T) {
+	fs := testFlagSet()
+	err := fs.Parse(normalizeArgs(fs, []string{"url", "-mk", "--output-document=out"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"mirror": "true", "convert-links": "true", "O": "out"} {
+		if got := fs.Lookup(name).Value.String(); got != want {
+			t.Errorf("-%s = %s, want %s", name, got, want)
+		}
+	}
+	if fmt.Sprint(fs.Args()) != "[url]" {
+		t.Errorf("Args = %q", fs.Args())
+	}
+	if canonicalFlag("limit-rate") != "rate-limit" || canonicalFlag("O") != "O" {
+		t.Error("canonicalFlag doesn't resolve aliases")
+	}
+}
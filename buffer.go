@@ -0,0 +1,37 @@
+package main
+
+import (
+	"io"
+	"sync"
+)
+
+// defaultBufferSize is the copy buffer used unless --buffer-size is
+// given. Going beyond io.Copy's 32KB cuts the syscalls per megabyte on
+// fast links and local disks; past 128KB there is little left to gain,
+// and network filesystems do no better with larger writes either.
+const defaultBufferSize = 128 * 1024
+
+// copyBuffers hands out the buffers used to stream downloads to disk, so
+// concurrent jobs do not each allocate their own
+var copyBuffers = newBufferPool(defaultBufferSize)
+
+type bufferPool struct {
+	size int
+	pool sync.Pool
+}
+
+func newBufferPool(size int) *bufferPool {
+	p := &bufferPool{size: size}
+	p.pool.New = func() any {
+		buf := make([]byte, p.size)
+		return &buf
+	}
+	return p
+}
+
+// copy is io.Copy with a pooled buffer
+func (p *bufferPool) copy(dst io.Writer, src io.Reader) (int64, error) {
+	buf := p.pool.Get().(*[]byte)
+	defer p.pool.Put(buf)
+	return io.CopyBuffer(dst, src, *buf)
+}
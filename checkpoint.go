@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"wget/mirror"
+)
+
+// runStats counts finished work for checkpoint summaries
+type runStats struct {
+	planned int64 // jobs known up front, for the ETA
+	files   int64
+	errors  int64
+
+	mirror atomic.Pointer[mirror.Mirror] // The mirror running, if one is; its bytes join usage once it ends
+}
+
+var stats = &runStats{}
+
+func (s *runStats) plan(n int) {
+	atomic.AddInt64(&s.planned, int64(n))
+}
+
+func (s *runStats) finished(err error) {
+	if err != nil {
+		atomic.AddInt64(&s.errors, 1)
+		return
+	}
+	atomic.AddInt64(&s.files, 1)
+}
+
+// eta estimates the time left from the average time per finished item
+func (s *runStats) eta(elapsed time.Duration) (time.Duration, bool) {
+	done := atomic.LoadInt64(&s.files) + atomic.LoadInt64(&s.errors)
+	remaining := atomic.LoadInt64(&s.planned) - done
+	if m := s.mirror.Load(); m != nil {
+		remaining = int64(m.Pending())
+	}
+	if done == 0 || remaining < 0 {
+		return 0, false
+	}
+	return time.Duration(int64(elapsed) / done * remaining), true
+}
+
+// summary formats one checkpoint line
+func (s *runStats) summary() string {
+	elapsed := time.Since(usage.start)
+	bytes := usage.written()
+	line := fmt.Sprintf("checkpoint: %d files, %.2f MiB, %d errors, elapsed %v",
+		atomic.LoadInt64(&s.files),
+		float64(bytes)/(1024*1024),
+		atomic.LoadInt64(&s.errors),
+		elapsed.Round(time.Second))
+	if eta, ok := s.eta(elapsed); ok {
+		line += fmt.Sprintf(", ETA %v", eta.Round(time.Second))
+	}
+	return line
+}
+
+// startCheckpoints logs a summary every interval. With rotate set, the
+// background log is rotated after each checkpoint so very long runs do
+// not produce one enormous file.
+func startCheckpoints(interval time.Duration, rotate bool) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			log.Println(stats.summary())
+			if rotate && logFile != nil {
+				if err := rotateLog(); err != nil {
+					log.Printf("rotating log: %v\n", err)
+				}
+			}
+		}
+	}()
+}
+
+// logFile is the background log, when -B is in effect
+var logFile *os.File
+
+// maxLogBackups is how many rotated logs (wget-log.1 ... wget-log.N) are kept
+const maxLogBackups = 5
+
+// rotateLog shifts wget-log to wget-log.1, wget-log.1 to wget-log.2 and
+// so on, then continues logging to a fresh wget-log
+func rotateLog() error {
+	name := logFile.Name()
+	for i := maxLogBackups - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", name, i), fmt.Sprintf("%s.%d", name, i+1))
+	}
+	if err := os.Rename(name, name+".1"); err != nil {
+		return err
+	}
+
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	log.SetOutput(f)
+	logFile.Close()
+	logFile = f
+	return nil
+}
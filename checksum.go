@@ -0,0 +1,63 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// newHash returns a hash for a digest name such as "sha-256", "sha256" or
+// "md5"
+func newHash(name string) (hash.Hash, error) {
+	switch strings.ReplaceAll(strings.ToLower(name), "-", "") {
+	case "md5":
+		return md5.New(), nil
+	case "sha1":
+		return sha1.New(), nil
+	case "sha224":
+		return sha256.New224(), nil
+	case "sha256":
+		return sha256.New(), nil
+	case "sha384":
+		return sha512.New384(), nil
+	case "sha512":
+		return sha512.New(), nil
+	}
+	return nil, fmt.Errorf("unsupported digest %q", name)
+}
+
+// checksumError reports a downloaded file whose digest does not match
+type checksumError struct {
+	file     string
+	expected string
+	actual   string
+}
+
+func (e *checksumError) Error() string {
+	return fmt.Sprintf("checksum mismatch for %s: expected %s, got %s", e.file, e.expected, e.actual)
+}
+
+// verifyChecksum compares the digest accumulated in h with the expected
+// hex value
+func verifyChecksum(file string, h hash.Hash, expected string) error {
+	actual := hex.EncodeToString(h.Sum(nil))
+	if !strings.EqualFold(actual, expected) {
+		return &checksumError{file: file, expected: expected, actual: actual}
+	}
+	return nil
+}
+
+// writeChecksumFile writes h as <file>.<digest> in the format read by
+// sha256sum -c and friends
+func writeChecksumFile(file, algo string, h hash.Hash) error {
+	ext := strings.ReplaceAll(strings.ToLower(algo), "-", "")
+	line := fmt.Sprintf("%s  %s\n", hex.EncodeToString(h.Sum(nil)), filepath.Base(file))
+	return os.WriteFile(file+"."+ext, []byte(line), 0644)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+)
+
+// serveControl listens on a Unix socket for commands that adjust a
+// running process, one per line:
+//
+//	rate          print the current rate limit
+//	rate 200k     change the rate limit (0 removes it)
+func serveControl(path string) error {
+	os.Remove(path) // stale socket from an earlier run
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				log.Printf("control socket: %v\n", err)
+				return
+			}
+			go handleControl(conn)
+		}
+	}()
+	return nil
+}
+
+// handleControl runs the commands sent on one control connection
+func handleControl(conn net.Conn) {
+	defer conn.Close()
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch {
+		case fields[0] == "rate" && len(fields) == 1:
+			fmt.Fprintf(conn, "rate %d\n", bandwidth.Rate())
+		case fields[0] == "rate" && len(fields) == 2:
+			rate, err := parseRateLimit(fields[1])
+			if err != nil {
+				fmt.Fprintf(conn, "error: %v\n", err)
+				continue
+			}
+			bandwidth.SetRate(rate)
+			log.Printf("rate limit changed to %d bytes/s\n", rate)
+			fmt.Fprintf(conn, "ok\n")
+		default:
+			fmt.Fprintf(conn, "error: unknown command %q\n", fields[0])
+		}
+	}
+}
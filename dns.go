@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// dnsCache resolves hostnames ahead of time so that downloads rarely block
+// on DNS. Lookups for the same host are shared, and answers are kept for
+// ttl before being resolved again.
+type dnsCache struct {
+	resolver *net.Resolver
+	ttl      time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*dnsEntry
+}
+
+type dnsEntry struct {
+	ready   chan struct{} // closed once addrs/err are set
+	addrs   []string
+	err     error
+	expires time.Time
+}
+
+func newDNSCache(ttl time.Duration) *dnsCache {
+	return &dnsCache{
+		resolver: net.DefaultResolver,
+		ttl:      ttl,
+		entries:  make(map[string]*dnsEntry),
+	}
+}
+
+// start returns the entry for host, beginning a background lookup if
+// there is no fresh one
+func (c *dnsCache) start(host string) *dnsEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[host]; ok {
+		select {
+		case <-e.ready:
+			if time.Now().Before(e.expires) {
+				return e
+			}
+		default:
+			return e // still resolving
+		}
+	}
+
+	e := &dnsEntry{ready: make(chan struct{})}
+	c.entries[host] = e
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		e.addrs, e.err = c.resolver.LookupHost(ctx, host)
+		e.expires = time.Now().Add(c.ttl)
+		if e.err != nil {
+			// Don't cache failures; the next dial tries again
+			c.mu.Lock()
+			if c.entries[host] == e {
+				delete(c.entries, host)
+			}
+			c.mu.Unlock()
+		}
+		close(e.ready)
+	}()
+	return e
+}
+
+// prefetch resolves host in the background
+func (c *dnsCache) prefetch(host string) {
+	if net.ParseIP(host) != nil {
+		return
+	}
+	c.start(host)
+}
+
+// lookup returns the addresses for host, waiting for a pending lookup
+func (c *dnsCache) lookup(ctx context.Context, host string) ([]string, error) {
+	e := c.start(host)
+	select {
+	case <-e.ready:
+		return e.addrs, e.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// dialFunc dials addr over network, like net.Dialer.DialContext
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// defaultFallbackDelay is net.Dialer's default head start for the first
+// address family
+const defaultFallbackDelay = 300 * time.Millisecond
+
+// dialContext wraps dial so that hostnames are resolved through the
+// cache. As dial only sees one address at a time, it races the address
+// families itself the way net.Dialer does (Happy Eyeballs): addresses of
+// the family listed first are tried in turn, and those of the other
+// family start after fallbackDelay, or as soon as the first family
+// fails. A negative fallbackDelay tries every address in turn instead.
+func (c *dnsCache) dialContext(dial dialFunc, fallbackDelay time.Duration) dialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil || net.ParseIP(host) != nil {
+			return dial(ctx, network, addr)
+		}
+
+		addrs, err := c.lookup(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+
+		primaries, fallbacks := splitFamilies(addrs)
+		if len(fallbacks) == 0 || fallbackDelay < 0 || network != "tcp" {
+			return dialSerial(ctx, dial, network, port, append(primaries, fallbacks...))
+		}
+		if fallbackDelay == 0 {
+			fallbackDelay = defaultFallbackDelay
+		}
+		return dialParallel(ctx, dial, network, port, primaries, fallbacks, fallbackDelay)
+	}
+}
+
+// splitFamilies splits addrs into those of the family of the first and
+// the rest
+func splitFamilies(addrs []string) (primaries, fallbacks []string) {
+	if len(addrs) == 0 {
+		return nil, nil
+	}
+	isIPv4 := func(addr string) bool {
+		ip := net.ParseIP(addr)
+		return ip != nil && ip.To4() != nil
+	}
+	first := isIPv4(addrs[0])
+	for _, addr := range addrs {
+		if isIPv4(addr) == first {
+			primaries = append(primaries, addr)
+		} else {
+			fallbacks = append(fallbacks, addr)
+		}
+	}
+	return primaries, fallbacks
+}
+
+// dialSerial tries each of ips in turn, returning the first error if
+// none connects
+func dialSerial(ctx context.Context, dial dialFunc, network, port string, ips []string) (net.Conn, error) {
+	var firstErr error
+	for _, ip := range ips {
+		conn, err := dial(ctx, network, net.JoinHostPort(ip, port))
+		if err == nil {
+			return conn, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, firstErr
+}
+
+// dialParallel races dialSerial over primaries against dialSerial over
+// fallbacks, started fallbackDelay later, and keeps the first connection
+// made
+func dialParallel(ctx context.Context, dial dialFunc, network, port string, primaries, fallbacks []string, fallbackDelay time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type dialResult struct {
+		conn net.Conn
+		err  error
+	}
+	results := make(chan dialResult, 2)
+	race := func(ips []string) {
+		go func() {
+			conn, err := dialSerial(ctx, dial, network, port, ips)
+			results <- dialResult{conn, err}
+		}()
+	}
+
+	race(primaries)
+	running := 1
+	fallbackStarted := false
+	timer := time.NewTimer(fallbackDelay)
+	defer timer.Stop()
+
+	var firstErr error
+	for {
+		select {
+		case <-timer.C:
+			if !fallbackStarted {
+				fallbackStarted = true
+				race(fallbacks)
+				running++
+			}
+		case r := <-results:
+			running--
+			if r.err == nil {
+				// The loser, if still dialing, is cancelled; a
+				// connection it makes anyway is closed
+				go func(n int) {
+					for ; n > 0; n-- {
+						if r := <-results; r.conn != nil {
+							r.conn.Close()
+						}
+					}
+				}(running)
+				return r.conn, nil
+			}
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			if !fallbackStarted {
+				fallbackStarted = true
+				race(fallbacks)
+				running++
+			}
+			if running == 0 {
+				return nil, firstErr
+			}
+		}
+	}
+}
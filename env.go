@@ -0,0 +1,46 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+// envVariables maps WGET_* environment variables to the flags they set.
+// They sit between the startup file and the command line: a variable
+// overrides ~/.wgetrc, and an explicit flag overrides the variable.
+var envVariables = map[string]string{
+	"WGET_OUTPUT_DOCUMENT": "O",
+	"WGET_OUTPUT_DIR":      "P",
+	"WGET_BACKGROUND":      "B",
+	"WGET_RATE_LIMIT":      "rate-limit",
+	"WGET_INPUT_FILE":      "i",
+	"WGET_MIRROR":          "mirror",
+	"WGET_REJECT":          "R",
+	"WGET_EXCLUDE":         "X",
+	"WGET_CONVERT_LINKS":   "convert-links",
+	"WGET_USER_AGENT":      "U",
+	"WGET_TRIES":           "t",
+	"WGET_PROXY":           "proxy",
+	"WGET_CONFIG":          "config",
+}
+
+// loadEnv applies WGET_* environment variables to every flag that was not
+// given on the command line. Empty variables are ignored.
+func loadEnv(fs *flag.FlagSet) error {
+	set := setFlags(fs)
+	for name, flagName := range envVariables {
+		value, ok := os.LookupEnv(name)
+		if !ok || value == "" || set[flagName] {
+			continue
+		}
+		f := fs.Lookup(flagName)
+		if f == nil {
+			continue
+		}
+		if err := setFlag(fs, f, value); err != nil {
+			return fmt.Errorf("invalid value for %s: %v", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConfigLayering(t *testing.T) {
+	rc := filepath.Join(t.TempDir(), "wgetrc")
+	err := os.WriteFile(rc, []byte("user_agent = rc\ntries = 5\nmirror = on\n"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("WGET_USER_AGENT", "env")
+	t.Setenv("WGET_TRIES", "7")
+	t.Setenv("WGET_MIRROR", "")
+
+	fs := flag.NewFlagSet("wget", flag.ContinueOnError)
+	agent := fs.String("U", "", "")
+	tries := fs.Int("t", 1, "")
+	mirror := fs.Bool("mirror", false, "")
+	if err := fs.Parse([]string{"-t", "2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The same order main applies them in
+	if err := loadEnv(fs); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadWgetrc(fs, rc, true); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		got, want any
+	}{
+		{"a variable overrides the file", *agent, "env"},
+		{"the command line overrides a variable", *tries, 2},
+		{"an empty variable is ignored", *mirror, true},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestLoadEnvInvalid(t *testing.T) {
+	t.Setenv("WGET_TRIES", "many")
+	fs := flag.NewFlagSet("wget", flag.ContinueOnError)
+	fs.Int("t", 1, "")
+	if err := loadEnv(fs); err == nil {
+		t.Error("loadEnv accepts WGET_TRIES=many")
+	}
+}
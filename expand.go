@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// maxExpansion caps the number of URLs a single pattern may produce
+const maxExpansion = 100000
+
+// expandURL expands brace expressions in a URL pattern:
+//
+//	{1..10}      numeric range, optionally with a step: {0..100..5}
+//	{001..100}   zero-padded to the width of the wider endpoint
+//	{a..z}       single-letter range
+//	{jpg,png}    list of alternatives
+//
+// Several expressions multiply: img{1..2}.{jpg,png} yields four URLs.
+// Braces that hold none of these forms are kept literally.
+func expandURL(pattern string) ([]string, error) {
+	open := strings.Index(pattern, "{")
+	if open < 0 {
+		return []string{pattern}, nil
+	}
+	end := strings.Index(pattern[open:], "}")
+	if end < 0 {
+		return []string{pattern}, nil
+	}
+	end += open
+
+	prefix, body, rest := pattern[:open], pattern[open+1:end], pattern[end+1:]
+	alternatives, err := expandBraces(body)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", pattern, err)
+	}
+	if alternatives == nil {
+		// Not an expression; keep the braces and expand what follows
+		alternatives = []string{"{" + body + "}"}
+	}
+
+	tails, err := expandURL(rest)
+	if err != nil {
+		return nil, err
+	}
+	if len(alternatives)*len(tails) > maxExpansion {
+		return nil, fmt.Errorf("%s: expands to more than %d URLs", pattern, maxExpansion)
+	}
+
+	urls := make([]string, 0, len(alternatives)*len(tails))
+	for _, alt := range alternatives {
+		for _, tail := range tails {
+			urls = append(urls, prefix+alt+tail)
+		}
+	}
+	return urls, nil
+}
+
+// expandBraces expands the text between one pair of braces. It returns nil
+// if the text is not a range or list.
+func expandBraces(body string) ([]string, error) {
+	if parts := strings.Split(body, ".."); len(parts) == 2 || len(parts) == 3 {
+		return expandRange(parts)
+	}
+	if strings.Contains(body, ",") {
+		return strings.Split(body, ","), nil
+	}
+	return nil, nil
+}
+
+// expandRange expands {start..end} or {start..end..step}
+func expandRange(parts []string) ([]string, error) {
+	step := 1
+	if len(parts) == 3 {
+		n, err := strconv.Atoi(parts[2])
+		if err != nil || n == 0 {
+			return nil, fmt.Errorf("invalid step %q", parts[2])
+		}
+		if n < 0 {
+			n = -n
+		}
+		step = n
+	}
+
+	start, end := parts[0], parts[1]
+	if len(start) == 1 && len(end) == 1 && isLetter(start[0]) && isLetter(end[0]) {
+		var out []string
+		for _, c := range stepInts(int(start[0]), int(end[0]), step) {
+			out = append(out, string(rune(c)))
+		}
+		return out, nil
+	}
+
+	from, err1 := strconv.Atoi(start)
+	to, err2 := strconv.Atoi(end)
+	if err1 != nil || err2 != nil {
+		return nil, nil
+	}
+
+	// Zero padding is requested by a leading zero on either endpoint
+	width := 0
+	if (len(start) > 1 && start[0] == '0') || (len(end) > 1 && end[0] == '0') {
+		width = len(start)
+		if len(end) > width {
+			width = len(end)
+		}
+	}
+
+	values := stepInts(from, to, step)
+	if len(values) > maxExpansion {
+		return nil, fmt.Errorf("range expands to more than %d values", maxExpansion)
+	}
+	out := make([]string, 0, len(values))
+	for _, n := range values {
+		out = append(out, fmt.Sprintf("%0*d", width, n))
+	}
+	return out, nil
+}
+
+// stepInts counts from from to to inclusive in either direction
+func stepInts(from, to, step int) []int {
+	var out []int
+	if from <= to {
+		for n := from; n <= to && len(out) <= maxExpansion; n += step {
+			out = append(out, n)
+		}
+	} else {
+		for n := from; n >= to && len(out) <= maxExpansion; n -= step {
+			out = append(out, n)
+		}
+	}
+	return out
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// expandURLs expands every pattern in urls
+func expandURLs(urls []string) ([]string, error) {
+	var out []string
+	for _, pattern := range urls {
+		expanded, err := expandURL(pattern)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, expanded...)
+	}
+	return out, nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestExpandURL(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    string
+	}{
+		{"http://x/a.jpg", "[http://x/a.jpg]"},
+		{"http://x/{1..3}.jpg", "[http://x/1.jpg http://x/2.jpg http://x/3.jpg]"},
+		{"http://x/{3..1}", "[http://x/3 http://x/2 http://x/1]"},
+		{"http://x/{0..10..5}", "[http://x/0 http://x/5 http://x/10]"},
+		{"http://x/{08..10}", "[http://x/08 http://x/09 http://x/10]"},
+		{"http://x/{a..c}", "[http://x/a http://x/b http://x/c]"},
+		{"http://x/{jpg,png}", "[http://x/jpg http://x/png]"},
+		{"http://x/{1..2}.{jpg,png}", "[http://x/1.jpg http://x/1.png http://x/2.jpg http://x/2.png]"},
+		{"http://x/{id}/{1..2}", "[http://x/{id}/1 http://x/{id}/2]"},
+		{"http://x/{a..1}", "[http://x/{a..1}]"},
+		{"http://x/{1..2", "[http://x/{1..2]"},
+	}
+	for _, tt := range tests {
+		got, err := expandURL(tt.pattern)
+		if err != nil {
+			t.Errorf("expandURL(%q): %v", tt.pattern, err)
+			continue
+		}
+		if fmt.Sprint(got) != tt.want {
+			t.Errorf("expandURL(%q) = %v, want %v", tt.pattern, got, tt.want)
+		}
+	}
+}
+
+func TestExpandURLErrors(t *testing.T) {
+	for _, pattern := range []string{
+		"http://x/{1..10..0}",
+		"http://x/{1..10..x}",
+		"http://x/{0..1000000}",
+		"http://x/{0..999}/{0..999}",
+	} {
+		if _, err := expandURL(pattern); err == nil {
+			t.Errorf("expandURL(%q) succeeds", pattern)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"mime"
+	"path/filepath"
+)
+
+// typeExtensions gives the preferred extension for common types.
+// mime.ExtensionsByType is consulted for anything else, but it returns
+// several candidates in no useful order (.jfif before .jpg, for example).
+var typeExtensions = map[string]string{
+	"application/json":              ".json",
+	"application/pdf":               ".pdf",
+	"application/zip":               ".zip",
+	"application/gzip":              ".gz",
+	"application/x-gzip":            ".gz",
+	"application/x-tar":             ".tar",
+	"application/xml":               ".xml",
+	"application/javascript":        ".js",
+	"application/octet-stream":      "",
+	"application/x-ndjson":          ".ndjson",
+	"application/vnd.ms-excel":      ".xls",
+	"application/x-yaml":            ".yaml",
+	"text/csv":                      ".csv",
+	"text/html":                     ".html",
+	"text/plain":                    ".txt",
+	"text/css":                      ".css",
+	"text/javascript":               ".js",
+	"text/xml":                      ".xml",
+	"image/jpeg":                    ".jpg",
+	"image/png":                     ".png",
+	"image/gif":                     ".gif",
+	"image/svg+xml":                 ".svg",
+	"image/webp":                    ".webp",
+	"audio/mpeg":                    ".mp3",
+	"video/mp4":                     ".mp4",
+	"application/vnd.ms-powerpoint": ".ppt",
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet":       ".xlsx",
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document": ".docx",
+}
+
+// extensionForType returns the file extension for a Content-Type header,
+// or "" if there is no sensible one
+func extensionForType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	if ext, ok := typeExtensions[mediaType]; ok {
+		return ext
+	}
+	exts, err := mime.ExtensionsByType(mediaType)
+	if err != nil || len(exts) == 0 {
+		return ""
+	}
+	return exts[0]
+}
+
+// addTypeExtension appends the extension implied by contentType to a
+// file name that has none
+func addTypeExtension(fileName, contentType string) string {
+	if filepath.Ext(fileName) != "" {
+		return fileName
+	}
+	return fileName + extensionForType(contentType)
+}
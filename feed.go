@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// maxFeedSize bounds how much of a feed document is read
+const maxFeedSize = 32 << 20
+
+// feedDoc holds what is read from an RSS 2.0 or Atom feed
+type feedDoc struct {
+	Channel struct {
+		Title string    `xml:"title"`
+		Items []rssItem `xml:"item"`
+	} `xml:"channel"`
+	Title   string      `xml:"title"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type rssItem struct {
+	Title      string `xml:"title"`
+	PubDate    string `xml:"pubDate"`
+	Enclosures []struct {
+		URL string `xml:"url,attr"`
+	} `xml:"enclosure"`
+	Media []struct {
+		URL string `xml:"url,attr"`
+	} `xml:"http://search.yahoo.com/mrss/ content"`
+}
+
+type atomEntry struct {
+	Title     string `xml:"title"`
+	Published string `xml:"published"`
+	Updated   string `xml:"updated"`
+	Links     []struct {
+		Href string `xml:"href,attr"`
+		Rel  string `xml:"rel,attr"`
+	} `xml:"link"`
+}
+
+// feedItem is an item of a feed with the files attached to it
+type feedItem struct {
+	feed       string // Title of the feed
+	title      string
+	date       time.Time // Zero if the feed gives none that parses
+	enclosures []string  // Absolute URLs
+}
+
+// feedDateLayouts are the date formats seen in RSS pubDate and Atom
+// published/updated elements
+var feedDateLayouts = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"2 Jan 2006 15:04:05 -0700",
+	time.RFC3339,
+}
+
+func parseFeedDate(s string) time.Time {
+	s = strings.TrimSpace(s)
+	for _, layout := range feedDateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// fetchFeed downloads and parses the feed at feedURL
+func fetchFeed(feedURL string, config Config) ([]feedItem, error) {
+	base, err := url.Parse(feedURL)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("GET", feedURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range config.headers {
+		for _, v := range values {
+			req.Header.Add(name, v)
+		}
+	}
+	if config.userAgent != "" {
+		req.Header.Set("User-Agent", config.userAgent)
+	}
+
+	client := config.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &statusError{status: resp.Status, code: resp.StatusCode}
+	}
+
+	var doc feedDoc
+	decoder := xml.NewDecoder(io.LimitReader(resp.Body, maxFeedSize))
+	// Feeds in other encodings are read as they are; the URLs that
+	// matter are ASCII
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("not an RSS or Atom feed: %v", err)
+	}
+
+	resolve := func(ref string) string {
+		u, err := url.Parse(strings.TrimSpace(ref))
+		if err != nil || ref == "" {
+			return ""
+		}
+		return base.ResolveReference(u).String()
+	}
+
+	var items []feedItem
+	for _, it := range doc.Channel.Items {
+		item := feedItem{feed: doc.Channel.Title, title: it.Title, date: parseFeedDate(it.PubDate)}
+		for _, e := range it.Enclosures {
+			item.enclosures = appendUnique(item.enclosures, resolve(e.URL))
+		}
+		for _, m := range it.Media {
+			item.enclosures = appendUnique(item.enclosures, resolve(m.URL))
+		}
+		items = append(items, item)
+	}
+	for _, entry := range doc.Entries {
+		date := entry.Published
+		if date == "" {
+			date = entry.Updated
+		}
+		item := feedItem{feed: doc.Title, title: entry.Title, date: parseFeedDate(date)}
+		for _, link := range entry.Links {
+			if link.Rel == "enclosure" {
+				item.enclosures = appendUnique(item.enclosures, resolve(link.Href))
+			}
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func appendUnique(list []string, s string) []string {
+	if s == "" {
+		return list
+	}
+	for _, item := range list {
+		if item == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
+
+// feedFileName fills in the --feed-name template for an enclosure of
+// item. The template may name directories with '/'.
+func feedFileName(template string, item feedItem, enclosure string) string {
+	name := "download"
+	if u, err := url.Parse(enclosure); err == nil {
+		if base := path.Base(u.Path); base != "/" && base != "." {
+			name = base
+		}
+	}
+	ext := path.Ext(name)
+	if stem := strings.TrimSuffix(name, ext); strings.EqualFold(path.Ext(stem), ".tar") {
+		ext = path.Ext(stem) + ext
+	}
+	date := "undated"
+	if !item.date.IsZero() {
+		date = item.date.Format("2006-01-02")
+	}
+
+	replacer := strings.NewReplacer(
+		"{feed}", cleanFileName(item.feed),
+		"{title}", cleanFileName(item.title),
+		"{date}", date,
+		"{name}", cleanFileName(name),
+		"{ext}", ext,
+	)
+	return filepath.FromSlash(replacer.Replace(template))
+}
+
+// cleanFileName makes s safe to use as one file name
+func cleanFileName(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r < ' ' || strings.ContainsRune(`/\<>:"|?*`, r) {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(s))
+	if len(s) > 120 {
+		s = s[:120]
+	}
+	s = strings.Trim(s, ". ")
+	if s == "" {
+		return "untitled"
+	}
+	return s
+}
+
+// feedHistory is the set of enclosures downloaded by earlier runs, kept
+// one URL per line
+type feedHistory struct {
+	path string
+	seen map[string]bool
+}
+
+func loadFeedHistory(path string) (*feedHistory, error) {
+	h := &feedHistory{path: path, seen: map[string]bool{}}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return h, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			h.seen[line] = true
+		}
+	}
+	return h, scanner.Err()
+}
+
+// add appends the enclosures just downloaded to the history file
+func (h *feedHistory) add(urls []string) error {
+	if len(urls) == 0 {
+		return nil
+	}
+	f, err := os.OpenFile(h.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	for _, u := range urls {
+		h.seen[u] = true
+		fmt.Fprintln(f, u)
+	}
+	return f.Close()
+}
+
+// runFeeds downloads the enclosures of every item of each feed that an
+// earlier run has not downloaded already
+func runFeeds(feeds []string, config Config) []downloadResult {
+	historyPath := config.feedHistory
+	if historyPath == "" {
+		historyPath = filepath.Join(config.outputDir, ".wget-feed-history")
+	}
+	history, err := loadFeedHistory(historyPath)
+	if err != nil {
+		fmt.Printf("Error reading feed history: %v\n", err)
+		return []downloadResult{{url: historyPath, err: err}}
+	}
+
+	var results []downloadResult
+	var jobs []downloadJob
+	queued := map[string]bool{}
+	for _, feedURL := range feeds {
+		items, err := fetchFeed(feedURL, config)
+		if err != nil {
+			log.Printf("Error reading feed %s: %v\n", feedURL, err)
+			results = append(results, downloadResult{url: feedURL, err: err})
+			continue
+		}
+
+		skipped := 0
+		for _, item := range items {
+			for _, enclosure := range item.enclosures {
+				if history.seen[enclosure] || queued[enclosure] {
+					skipped++
+					continue
+				}
+				queued[enclosure] = true
+
+				name := feedFileName(config.feedName, item, enclosure)
+				job := downloadJob{url: enclosure, config: config}
+				job.config.outputDir = filepath.Join(config.outputDir, filepath.Dir(name))
+				job.config.outputFile = filepath.Base(name)
+				jobs = append(jobs, job)
+			}
+		}
+		fmt.Printf("Feed %s: %d items, %d enclosures already downloaded\n", feedURL, len(items), skipped)
+	}
+
+	stats.plan(len(jobs))
+	downloads := runJobs(jobs, config.concurrency, config.perHost)
+	var done []string
+	for _, r := range downloads {
+		if r.err == nil {
+			done = append(done, r.url)
+		}
+	}
+	if err := history.add(done); err != nil {
+		fmt.Printf("Error writing feed history: %v\n", err)
+	}
+	return append(results, downloads...)
+}
@@ -2,4 +2,10 @@ module wget
 
 go 1.21
 
-require golang.org/x/net v0.21.0
+require (
+	go.etcd.io/bbolt v1.3.10
+	golang.org/x/net v0.21.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require golang.org/x/sys v0.17.0 // indirect
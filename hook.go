@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// runHook runs the --exec command after a successful download. The
+// placeholders {file}, {url} and {size} are replaced with shell-quoted
+// values, and the same values are exported as WGET_FILE, WGET_URL and
+// WGET_SIZE.
+func runHook(command, url string, saved savedFile) error {
+	size := strconv.FormatInt(saved.size, 10)
+	expanded := strings.NewReplacer(
+		"{file}", shellQuote(saved.path),
+		"{url}", shellQuote(url),
+		"{size}", size,
+	).Replace(command)
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", expanded)
+	} else {
+		cmd = exec.Command("sh", "-c", expanded)
+	}
+	cmd.Env = append(os.Environ(),
+		"WGET_FILE="+saved.path,
+		"WGET_URL="+url,
+		"WGET_SIZE="+size,
+	)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("--exec %q: %v", command, err)
+	}
+	return nil
+}
+
+// shellQuote quotes s for the platform shell
+func shellQuote(s string) string {
+	if runtime.GOOS == "windows" {
+		return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,32 @@
+package main
+
+import "sync"
+
+// hostSlots limits how many jobs run against each host at once
+type hostSlots struct {
+	limit int
+	mu    sync.Mutex
+	slots map[string]chan struct{}
+}
+
+func newHostSlots(limit int) *hostSlots {
+	return &hostSlots{limit: limit, slots: map[string]chan struct{}{}}
+}
+
+// acquire blocks until host has a free slot and returns the function that
+// gives it back
+func (h *hostSlots) acquire(host string) func() {
+	if h.limit <= 0 {
+		return func() {}
+	}
+	h.mu.Lock()
+	slot, ok := h.slots[host]
+	if !ok {
+		slot = make(chan struct{}, h.limit)
+		h.slots[host] = slot
+	}
+	h.mu.Unlock()
+
+	slot <- struct{}{}
+	return func() { <-slot }
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// downloadJob is one URL to download with the options that apply to it
+type downloadJob struct {
+	url    string
+	config Config
+}
+
+// parseInputFile reads an -i input file. Each non-indented line is a URL;
+// indented "name=value" lines that follow it set options for that URL
+// only, as in aria2 input files:
+//
+//	https://example.com/file.iso
+//	  out=debian.iso
+//	  dir=isos
+//	  checksum=sha-256=8f1c...
+//
+// Blank lines and lines starting with '#' are ignored.
+func parseInputFile(r io.Reader, base Config) ([]downloadJob, error) {
+	var jobs []downloadJob
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		if trimmed == line {
+			jobs = append(jobs, downloadJob{url: trimmed, config: base})
+			continue
+		}
+
+		if len(jobs) == 0 {
+			return nil, fmt.Errorf("line %d: option before any URL", lineNo)
+		}
+		name, value, ok := strings.Cut(trimmed, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected name=value", lineNo)
+		}
+		if err := setJobOption(&jobs[len(jobs)-1].config, base, name, value); err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNo, err)
+		}
+	}
+	return jobs, scanner.Err()
+}
+
+// setJobOption applies one per-URL option
+func setJobOption(config *Config, base Config, name, value string) error {
+	switch strings.TrimSpace(name) {
+	case "out":
+		config.outputFile = value
+	case "dir":
+		// Relative directories are placed under -P
+		if !filepath.IsAbs(value) && base.outputDir != "" {
+			value = filepath.Join(base.outputDir, value)
+		}
+		config.outputDir = value
+	case "checksum":
+		algo, digest, ok := strings.Cut(value, "=")
+		if !ok {
+			return fmt.Errorf("checksum must be <digest>=<hex>")
+		}
+		if _, err := newHash(algo); err != nil {
+			return err
+		}
+		config.checksumAlgo, config.checksum = algo, digest
+	default:
+		return fmt.Errorf("unknown option %q", name)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseInputFile(t *testing.T) {
+	input := `# nightly images
+https://example.com/a.iso
+  out=debian.iso
+  dir=isos
+
+https://example.com/b.iso
+	checksum=sha256=00ff
+`
+	jobs, err := parseInputFile(strings.NewReader(input), Config{outputDir: "dl"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 2 {
+		t.Fatalf("parseInputFile = %d jobs, want 2", len(jobs))
+	}
+
+	tests := []struct {
+		name      string
+		got, want string
+	}{
+		{"first url", jobs[0].url, "https://example.com/a.iso"},
+		{"out", jobs[0].config.outputFile, "debian.iso"},
+		{"dir under -P", jobs[0].config.outputDir, filepath.Join("dl", "isos")},
+		{"second url", jobs[1].url, "https://example.com/b.iso"},
+		{"options stay with their url", jobs[1].config.outputDir, "dl"},
+		{"checksum", jobs[1].config.checksumAlgo + " " + jobs[1].config.checksum, "sha256 00ff"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestParseInputFileErrors(t *testing.T) {
+	for _, input := range []string{
+		"  out=a\nhttps://example.com/\n",
+		"https://example.com/\n  out\n",
+		"https://example.com/\n  colour=blue\n",
+		"https://example.com/\n  checksum=00ff\n",
+	} {
+		if _, err := parseInputFile(strings.NewReader(input), Config{}); err == nil {
+			t.Errorf("parseInputFile(%q) succeeds", input)
+		}
+	}
+}
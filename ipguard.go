@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+)
+
+// ipGuard refuses connections to private, loopback and link-local
+// addresses so that a crawled site cannot point the crawler at the local
+// network. The check runs on the address actually being dialed, after
+// DNS resolution, which also defeats DNS rebinding.
+type ipGuard struct {
+	allow []*net.IPNet
+}
+
+// sharedAddressSpace is the carrier-grade NAT range (RFC 6598), which
+// net.IP.IsPrivate does not cover
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// newIPGuard parses a comma-separated allow-list of CIDRs or single
+// addresses that remain reachable despite being private
+func newIPGuard(allow string) (*ipGuard, error) {
+	g := &ipGuard{}
+	for _, entry := range strings.Split(allow, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q", entry)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				bits = 32
+			}
+			g.allow = append(g.allow, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, err
+		}
+		g.allow = append(g.allow, network)
+	}
+	return g, nil
+}
+
+// check returns an error if ip is internal and not allow-listed
+func (g *ipGuard) check(ip net.IP) error {
+	for _, network := range g.allow {
+		if network.Contains(ip) {
+			return nil
+		}
+	}
+	if ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsUnspecified() || ip.IsMulticast() ||
+		sharedAddressSpace.Contains(ip) {
+		return fmt.Errorf("refusing to connect to internal address %s", ip)
+	}
+	return nil
+}
+
+// control is a net.Dialer Control function that vets each address before
+// the connection is made. The socket given with --unix-socket is not an
+// internet address and is let through.
+func (g *ipGuard) control(network, address string, _ syscall.RawConn) error {
+	if strings.HasPrefix(network, "unix") {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("unresolved address %q", address)
+	}
+	return g.check(ip)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"os"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// loginStep is one request made before a mirror crawl to establish a
+// session
+type loginStep struct {
+	method string
+	url    string
+	body   string // sent as a form when not empty
+}
+
+// loginSteps returns the requests --login asks for. A URL is a form to
+// POST --login-data to. "@file" names a script of requests, one per
+// line as "METHOD URL [BODY]", with blank lines and # comments ignored.
+func loginSteps(config Config) ([]loginStep, error) {
+	if !strings.HasPrefix(config.login, "@") {
+		return []loginStep{{method: "POST", url: config.login, body: config.loginData}}, nil
+	}
+
+	f, err := os.Open(strings.TrimPrefix(config.login, "@"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var steps []loginStep
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.SplitN(text, " ", 3)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("%s:%d: expected \"METHOD URL [BODY]\"", f.Name(), line)
+		}
+		step := loginStep{method: strings.ToUpper(fields[0]), url: fields[1]}
+		if len(fields) == 3 {
+			step.body = strings.TrimSpace(fields[2])
+		}
+		steps = append(steps, step)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("%s has no requests", f.Name())
+	}
+	return steps, nil
+}
+
+// login makes the --login requests with a copy of the shared client that
+// keeps cookies, and returns that client so the crawl carries the
+// session they set up
+func login(config Config) (*http.Client, error) {
+	steps, err := loginSteps(config)
+	if err != nil {
+		return nil, err
+	}
+
+	jar, err := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Jar: jar}
+	if config.client != nil {
+		*client = *config.client
+		client.Jar = jar
+	}
+
+	for _, step := range steps {
+		var body io.Reader
+		if step.body != "" {
+			body = strings.NewReader(step.body)
+		}
+		req, err := http.NewRequest(step.method, step.url, body)
+		if err != nil {
+			return nil, err
+		}
+		for name, values := range config.headers {
+			for _, v := range values {
+				req.Header.Add(name, v)
+			}
+		}
+		if config.userAgent != "" {
+			req.Header.Set("User-Agent", config.userAgent)
+		}
+		if step.body != "" {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		fmt.Printf("Login: %s %s: %s\n", step.method, step.url, resp.Status)
+		if resp.StatusCode >= 400 {
+			return nil, fmt.Errorf("%s %s: %s", step.method, step.url, resp.Status)
+		}
+	}
+	return client, nil
+}
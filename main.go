@@ -1,14 +1,19 @@
 package main
 
 import (
-	"bufio"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"hash"
 	"io"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 	"sync"
@@ -28,18 +33,177 @@ type Config struct {
 	reject        string
 	exclude       string
 	convertLinks  bool
+	level         string // -l, "inf" or a number
+	maxDepth      int    // level after parsing; 0 for unlimited
+	noParent      bool
+	mirrorWorkers int
+	wait          time.Duration
+	waitJitter    time.Duration
+
+	noHostDirectories bool
+	cutDirs           int
+	noDirectories     bool
+	adjustExtension   bool
+	backupConverted   bool
+	convertFileOnly   bool
+	restrictFileNames string
+	timestamping      bool
+
+	noServerTimestamps bool
+
+	continueMirror bool
+	mirrorState    string
+	visitedStore   string
+
+	mirrorManifest string
+
+	saveHeaders    bool
+	headersSidecar bool
+
+	feed        bool
+	feedName    string
+	feedHistory string
+
+	deleteAfter bool
+	brokenLinks string
+	summaryFile string
+	listURLs    bool
+	rewrites    rewriteFlag
+	sortQuery   bool
+	dedupe      string
+	quota       string
+	crawlOrder  string
+
+	maxPages     int
+	keepFrontier bool
+
+	login     string
+	loginData string
+
+	warcFile          string
+	noWARCCompression bool
+	fromWARC          string
+
+	archive     string
+	archiveOnly bool
+
+	followSelector string
+	lazyAttrs      string
+
+	pageRequisites bool
+	robots         bool
+	nofollow       bool
+	sitemaps       bool
+
+	accept           string
+	removeUnaccepted bool
+	acceptRegex      string
+	rejectRegex      string
+	include          string
+	commands       commandFlag // -e wgetrc commands
+
+	spanHosts      bool
+	domains        string
+	excludeDomains string
+
+	userAgent   string
+	tries       int
+	proxy       string
+	configFile  string
+	reportUsage bool
+	metricsAddr string
+	dnsPrefetch bool
+
+	controlSocket string
+	strictArchive bool
+	expand        bool
+
+	spider        bool
+	slowThreshold time.Duration
+	blockPrivate  bool
+	allowNet      string
+	headers       http.Header
+	concurrency   int
+	perHost       int
+	manifest      string
+	resultFile    string
+	transcodeUTF8 bool
+	execCmd       string
+	notifyWebhook string
+	notifyDesktop bool
+	fromURLList   string
+	writeChecksum string
+
+	checkpointInterval time.Duration
+	rotateLog          bool
+
+	signatureURL  string
+	keyring       string
+	quarantineDir string
+	diffReport    string
+
+	continueDownload bool
+
+	preflight   bool
+	maxFilesize string
+	maxBytes    int64 // maxFilesize after parsing
+
+	noTypeExtension bool
+
+	maxIdlePerHost  int
+	maxConnsPerHost int
+	idleTimeout     time.Duration
+
+	bufferSize string
+
+	unixSocket string
+
+	rateSchedule string
+
+	watch   time.Duration
+	startAt string
+
+	connectTimeout time.Duration
+	keepAlive      time.Duration
+	fallbackDelay  time.Duration
+
+	readTimeout   time.Duration
+	minSpeed      string
+	minSpeedBytes int64 // minSpeed after parsing
+	minSpeedTime  time.Duration
+
+	retryOn        string
+	backoff        time.Duration
+	maxBackoff     time.Duration
+	attemptTimeout time.Duration
+	retryHosts     retryHostFlag
+	retry          *mirror.RetryRules // built from the retry flags
+
+	checksumAlgo string // digest to verify, set per URL in the -i file
+	checksum     string // expected hex digest
+
+	client   *http.Client // built from proxy once flags are parsed
+	dnsCache *dnsCache    // set when dnsPrefetch is on
 }
 
+// progressInterval is how often the progress line is redrawn
+const progressInterval = 100 * time.Millisecond
+
 type DownloadProgress struct {
 	total     int64
 	current   int64
 	startTime time.Time
+	lastPrint time.Time
 }
 
 func (dp *DownloadProgress) Write(p []byte) (int, error) {
 	n := len(p)
 	dp.current += int64(n)
-	dp.printProgress()
+	// Redrawing on every write costs more than the copy on fast links
+	if dp.current == dp.total || time.Since(dp.lastPrint) >= progressInterval {
+		dp.lastPrint = time.Now()
+		dp.printProgress()
+	}
 	return n, nil
 }
 
@@ -106,126 +270,771 @@ func parseRateLimit(rateLimit string) (int64, error) {
 	return rate * multiplier, nil
 }
 
-type rateLimitedReader struct {
-	r        io.Reader
-	rateBytes int64 // bytes per second
-	lastRead time.Time
-	bytesRead int64
+// statusError reports a non-200 response
+type statusError struct {
+	status string
+	code   int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("bad status: %s", e.status)
+}
+
+// shortReadError reports a connection that closed before the whole body
+// announced by Content-Length arrived
+type shortReadError struct {
+	got  int64
+	want int64
+}
+
+func (e *shortReadError) Error() string {
+	return fmt.Sprintf("connection closed after %d of %d bytes", e.got, e.want)
+}
+
+func isShortRead(err error) bool {
+	var se *shortReadError
+	return errors.As(err, &se)
+}
+
+// statusCode returns the HTTP status behind err, or 0 if there was no
+// response
+func statusCode(err error) int {
+	var se *statusError
+	if errors.As(err, &se) {
+		return se.code
+	}
+	return 0
 }
 
-func newRateLimitedReader(r io.Reader, rateBytes int64) io.Reader {
-	return &rateLimitedReader{
-		r:        r,
-		rateBytes: rateBytes,
-		lastRead: time.Now(),
+// newHTTPClient builds the client shared by every download, mirror and
+// check of the run, so keep-alive connections are reused across them and
+// the per-host limits apply to the run as a whole. An explicit proxy
+// overrides the usual HTTP_PROXY/HTTPS_PROXY variables.
+func newHTTPClient(config Config) (*http.Client, error) {
+	proxy := http.ProxyFromEnvironment
+	if config.proxy != "" {
+		proxyURL, err := url.Parse(config.proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy %q: %v", config.proxy, err)
+		}
+		proxy = http.ProxyURL(proxyURL)
+	}
+
+	dialer := &net.Dialer{
+		Timeout:       config.connectTimeout,
+		KeepAlive:     config.keepAlive,
+		FallbackDelay: config.fallbackDelay,
+	}
+	if config.blockPrivate {
+		guard, err := newIPGuard(config.allowNet)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --allow-net: %v", err)
+		}
+		dialer.Control = guard.control
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = proxy
+	transport.MaxIdleConnsPerHost = config.maxIdlePerHost
+	transport.MaxConnsPerHost = config.maxConnsPerHost
+	transport.IdleConnTimeout = config.idleTimeout
+	transport.ResponseHeaderTimeout = config.readTimeout
+	dial := dialer.DialContext
+	if config.dnsCache != nil {
+		dial = config.dnsCache.dialContext(dial, config.fallbackDelay)
+	}
+	if config.unixSocket != "" {
+		// Every request goes to the socket; the URL host only ends up
+		// in the Host header
+		dial = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", config.unixSocket)
+		}
+		transport.Proxy = nil
 	}
+	transport.DialContext = countingDialContext(dial)
+	return &http.Client{Transport: transport}, nil
 }
 
-func (r *rateLimitedReader) Read(p []byte) (n int, err error) {
-	if r.rateBytes <= 0 {
-		return r.r.Read(p)
+// savedFile describes a file written by a successful download
+type savedFile struct {
+	path string
+	size int64
+}
+
+// downloadFile downloads url, retrying failures as the retry policy
+// for its host allows
+func downloadFile(url string, config Config) (savedFile, error) {
+	strategy := config.retry.For(urlHost(url))
+
+	var saved savedFile
+	var err error
+	keepPartial := config.continueDownload
+	tailResumed := false
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.Background(), context.CancelFunc(func() {})
+		if timeout := strategy.Timeout(); timeout > 0 {
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+		}
+		err = nil
+		if config.preflight || config.maxBytes > 0 {
+			err = preflight(ctx, url, config)
+		}
+		if err == nil {
+			saved, err = fetchFile(ctx, url, config)
+		}
+		cancel()
+		if err == nil || isTooLarge(err) {
+			break
+		}
+
+		delay, retry := strategy.Retry(attempt, statusCode(err), err)
+		if isShortRead(err) || isStall(err) {
+			// Fetch only the missing tail next time. That is tried
+			// once even when -t allows no more attempts.
+			if !retry && !tailResumed {
+				delay, retry = 0, true
+			}
+			tailResumed = true
+			config.continueDownload = true
+		}
+		if !retry {
+			break
+		}
+		fmt.Printf("%v; retrying in %v (attempt %d)...\n", err, delay, attempt+1)
+		time.Sleep(delay)
 	}
-	
-	now := time.Now()
-	expectedDuration := time.Duration(float64(r.bytesRead) / float64(r.rateBytes) * float64(time.Second))
-	actualDuration := now.Sub(r.lastRead)
-	
-	if actualDuration < expectedDuration {
-		time.Sleep(expectedDuration - actualDuration)
+	if err != nil && !keepPartial {
+		// Only -c picks the download up later
+		if key, kerr := outputPath(url, config); kerr == nil {
+			removeResumeInfo(key)
+		}
+	}
+	if err == nil && config.keyring != "" {
+		err = verifySignature(url, saved, config)
+	}
+	if err == nil && config.execCmd != "" {
+		err = runHook(config.execCmd, url, saved)
+	}
+	if err == nil && config.deleteAfter {
+		os.Remove(saved.path)
+	}
+	return saved, err
+}
+
+// outputPath returns where url is saved, creating the output directory
+func outputPath(url string, config Config) (string, error) {
+	fileName := config.outputFile
+	if fileName == "" {
+		fileName = defaultFileName(url)
 	}
 	
-	n, err = r.r.Read(p)
-	r.bytesRead += int64(n)
-	return
+	if config.outputDir != "" {
+		err := os.MkdirAll(config.outputDir, 0755)
+		if err != nil {
+			return "", err
+		}
+		fileName = filepath.Join(config.outputDir, fileName)
+	}
+	return fileName, nil
 }
 
-func downloadFile(url string, config Config) error {
+// defaultFileName returns the name a download from rawURL is saved under
+// without -O: the last path segment, or index.html for directory URLs
+// such as https://example.com/
+func defaultFileName(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err == nil && (u.Path == "" || strings.HasSuffix(u.Path, "/")) {
+		return "index.html"
+	}
+	return filepath.Base(rawURL)
+}
+
+// fetchFile makes a single attempt at downloading url. With -c, a partial
+// file left by an earlier attempt is completed with a Range request.
+func fetchFile(ctx context.Context, url string, config Config) (savedFile, error) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
 	startTime := time.Now()
 	fmt.Printf("start at %s\n", startTime.Format("2006-01-02 15:04:05"))
 
-	resp, err := http.Get(url)
+	// key is the name known before the response arrives, which the
+	// resume sidecar is kept under; fileName may gain a type extension
+	key, err := outputPath(url, config)
 	if err != nil {
-		return err
+		return savedFile{}, err
+	}
+	fileName := key
+
+	var offset int64
+	if config.continueDownload {
+		fileName = resumedFileName(key, url)
+		if fi, err := os.Stat(fileName); err == nil && fi.Mode().IsRegular() {
+			offset = fi.Size()
+		} else {
+			fileName = key
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return savedFile{}, err
+	}
+	for name, values := range config.headers {
+		for _, v := range values {
+			req.Header.Add(name, v)
+		}
+	}
+	if config.userAgent != "" {
+		req.Header.Set("User-Agent", config.userAgent)
+	}
+	if offset > 0 {
+		setResumeHeaders(req, key, offset)
+	}
+
+	client := config.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return savedFile{}, err
 	}
 	defer resp.Body.Close()
 
 	fmt.Printf("sending request, awaiting response... status %s\n", resp.Status)
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("bad status: %s", resp.Status)
+	switch {
+	case offset > 0 && resp.StatusCode == http.StatusPartialContent:
+		fmt.Printf("resuming at byte %d\n", offset)
+	case offset > 0 && resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		fmt.Printf("file is already fully retrieved; nothing to do\n")
+		removeResumeInfo(key)
+		return savedFile{path: fileName, size: offset}, nil
+	case resp.StatusCode == http.StatusOK:
+		if offset > 0 {
+			// The server ignored the range, or If-Range found the file
+			// changed: the partial copy is useless
+			fmt.Printf("server sent the whole file; restarting from scratch\n")
+			offset = 0
+		}
+	default:
+		return savedFile{}, &statusError{status: resp.Status, code: resp.StatusCode}
 	}
 
-	contentLength := resp.ContentLength
-	fmt.Printf("content size: %d [~%.2fMB]\n", contentLength, float64(contentLength)/(1024*1024))
-
-	fileName := config.outputFile
-	if fileName == "" {
-		fileName = filepath.Base(url)
+	if offset == 0 {
+		fileName = key
+		if config.outputFile == "" && !config.noTypeExtension {
+			fileName = addTypeExtension(key, resp.Header.Get("Content-Type"))
+		}
 	}
-	
-	if config.outputDir != "" {
-		err = os.MkdirAll(config.outputDir, 0755)
-		if err != nil {
-			return err
+
+	contentLength := resp.ContentLength
+	if contentLength >= 0 {
+		if err := checkSize(offset+contentLength, config); err != nil {
+			return savedFile{}, err
 		}
-		fileName = filepath.Join(config.outputDir, fileName)
 	}
+	fmt.Printf("content size: %d [~%.2fMB]\n", contentLength, float64(contentLength)/(1024*1024))
 
 	fmt.Printf("saving file to: %s\n", fileName)
 
-	out, err := os.Create(fileName)
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if offset > 0 {
+		flags = os.O_WRONLY | os.O_APPEND
+	}
+	out, err := os.OpenFile(fileName, flags, 0644)
 	if err != nil {
-		return err
+		return savedFile{}, err
 	}
 	defer out.Close()
+	// Kept even without -c: a truncated transfer is resumed on retry
+	saveResumeInfo(key, fileName, url, resp)
 
 	progress := &DownloadProgress{
 		total:     contentLength,
+		current:   offset,
 		startTime: time.Now(),
 	}
+	if contentLength >= 0 {
+		progress.total += offset
+	}
+
+	reader := io.TeeReader(watchStalls(ctx, cancel, resp.Body, config), progress)
+	reader = newRateLimitedReader(reader, bandwidth)
 
-	reader := io.TeeReader(resp.Body, progress)
-	if config.rateBytes > 0 {
-		reader = newRateLimitedReader(reader, config.rateBytes)
+	// Digests are computed while streaming so huge files are read once
+	var hashes []io.Writer
+	var digest, sidecar hash.Hash
+	if config.checksum != "" {
+		if digest, err = newHash(config.checksumAlgo); err != nil {
+			return savedFile{}, err
+		}
+		hashes = append(hashes, digest)
+	}
+	if config.writeChecksum != "" {
+		if sidecar, err = newHash(config.writeChecksum); err != nil {
+			return savedFile{}, err
+		}
+		hashes = append(hashes, sidecar)
+	}
+	if offset > 0 && len(hashes) > 0 {
+		// Resumed: the digests must cover the part already on disk
+		if err := hashExisting(fileName, offset, io.MultiWriter(hashes...)); err != nil {
+			return savedFile{}, err
+		}
 	}
+	dst := io.MultiWriter(append([]io.Writer{out, usageWriter{}}, hashes...)...)
 
-	_, err = io.Copy(out, reader)
+	written, err := copyBuffers.copy(dst, reader)
+	if cause := context.Cause(ctx); err != nil && isStall(cause) {
+		return savedFile{}, cause
+	}
+	if contentLength >= 0 && written < contentLength && (err == nil || errors.Is(err, io.ErrUnexpectedEOF)) {
+		return savedFile{}, &shortReadError{got: offset + written, want: offset + contentLength}
+	}
 	if err != nil {
-		return err
+		return savedFile{}, err
+	}
+	removeResumeInfo(key)
+
+	if digest != nil {
+		if err := verifyChecksum(fileName, digest, config.checksum); err != nil {
+			return savedFile{}, err
+		}
+	}
+	if sidecar != nil {
+		if err := writeChecksumFile(fileName, config.writeChecksum, sidecar); err != nil {
+			return savedFile{}, err
+		}
+	}
+
+	// Date the file as the server does
+	if !config.noServerTimestamps {
+		if modified, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+			out.Close()
+			if err := os.Chtimes(fileName, modified, modified); err != nil {
+				return savedFile{}, err
+			}
+		}
 	}
 
 	fmt.Printf("\nDownloaded [%s]\n", url)
 	fmt.Printf("finished at %s\n", time.Now().Format("2006-01-02 15:04:05"))
-	return nil
+	return savedFile{path: fileName, size: offset + written}, nil
 }
 
-func downloadMultipleFiles(inputFile string, config Config) error {
+// readInputFile parses an -i file into jobs, expanding URL patterns if
+// --expand is set
+func readInputFile(inputFile string, config Config) ([]downloadJob, error) {
 	file, err := os.Open(inputFile)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer file.Close()
 
-	var urls []string
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		urls = append(urls, scanner.Text())
+	jobs, err := parseInputFile(file, config)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", inputFile, err)
+	}
+	if !config.expand {
+		return jobs, nil
 	}
 
+	var expanded []downloadJob
+	for _, job := range jobs {
+		urls, err := expandURL(job.url)
+		if err != nil {
+			return nil, err
+		}
+		for _, u := range urls {
+			expanded = append(expanded, downloadJob{url: u, config: job.config})
+		}
+	}
+	return expanded, nil
+}
+
+func downloadMultipleFiles(inputFile string, config Config) ([]downloadResult, error) {
+	jobs, err := readInputFile(inputFile, config)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.dnsCache != nil {
+		for _, job := range jobs {
+			if u, err := url.Parse(job.url); err == nil {
+				config.dnsCache.prefetch(u.Hostname())
+			}
+		}
+	}
+
+	stats.plan(len(jobs))
+	return runJobs(jobs, config.concurrency, config.perHost), nil
+}
+
+// runJobs downloads jobs with at most workers transfers at a time, and at
+// most perHost of them to any one host; zero lifts either limit. A job
+// waiting for its host does not hold up jobs for other hosts.
+func runJobs(jobs []downloadJob, workers, perHost int) []downloadResult {
+	if workers <= 0 {
+		workers = len(jobs)
+	}
+
+	results := make([]downloadResult, len(jobs))
+	sem := make(chan struct{}, workers)
+	hosts := newHostSlots(perHost)
 	var wg sync.WaitGroup
-	for _, url := range urls {
+	for i, job := range jobs {
 		wg.Add(1)
-		go func(url string) {
+		go func(i int, job downloadJob) {
 			defer wg.Done()
-			if err := downloadFile(url, config); err != nil {
-				log.Printf("Error downloading %s: %v\n", url, err)
+			release := hosts.acquire(urlHost(job.url))
+			defer release()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			saved, err := downloadFile(job.url, job.config)
+			if err != nil {
+				log.Printf("Error downloading %s: %v\n", job.url, err)
 			}
-		}(url)
+			results[i] = downloadResult{url: job.url, file: saved, err: err}
+			stats.finished(err)
+			notifyResult(job.config, results[i])
+		}(i, job)
 	}
 	wg.Wait()
-	return nil
+	return results
+}
+
+// mirrorPageList archives the pages listed in listFile, one URL per line
+func mirrorPageList(listFile string, config Config) error {
+	jobs, err := readInputFile(listFile, config)
+	if err != nil {
+		return err
+	}
+	if len(jobs) == 0 {
+		return fmt.Errorf("%s: no URLs", listFile)
+	}
+
+	var pages []string
+	for _, job := range jobs {
+		pages = append(pages, job.url)
+	}
+	return runMirror(pages[0], pages, config)
+}
+
+// mirrorSite mirrors the website at rawURL
+func mirrorSite(rawURL string, config Config) error {
+	return runMirror(rawURL, nil, config)
+}
+
+// parseLevel parses the -l recursion depth; "inf" and 0 mean unlimited
+func parseLevel(level string) (int, error) {
+	if level == "" || strings.EqualFold(level, "inf") {
+		return 0, nil
+	}
+	depth, err := strconv.Atoi(level)
+	if err != nil || depth < 0 {
+		return 0, fmt.Errorf("invalid recursion level %q", level)
+	}
+	return depth, nil
+}
+
+// runMirror mirrors from rawURL, or archives only pages if a page list
+// is given
+func runMirror(rawURL string, pages []string, config Config) error {
+	// Convert reject and exclude flags to slices
+	rejectTypes := []string{}
+	if config.reject != "" {
+		rejectTypes = strings.Split(config.reject, ",")
+	}
+
+	var acceptTypes []string
+	if config.accept != "" {
+		acceptTypes = strings.Split(config.accept, ",")
+	}
+
+	var acceptRegex, rejectRegex *regexp.Regexp
+	if config.acceptRegex != "" {
+		re, err := regexp.Compile(config.acceptRegex)
+		if err != nil {
+			return fmt.Errorf("invalid --accept-regex: %v", err)
+		}
+		acceptRegex = re
+	}
+	if config.rejectRegex != "" {
+		re, err := regexp.Compile(config.rejectRegex)
+		if err != nil {
+			return fmt.Errorf("invalid --reject-regex: %v", err)
+		}
+		rejectRegex = re
+	}
+
+	excludePaths := []string{}
+	if config.exclude != "" {
+		excludePaths = strings.Split(config.exclude, ",")
+	}
+
+	var includePaths []string
+	if config.include != "" {
+		includePaths = strings.Split(config.include, ",")
+	}
+
+	var domains, excludeDomains []string
+	if config.domains != "" {
+		domains = strings.Split(config.domains, ",")
+	}
+	if config.excludeDomains != "" {
+		excludeDomains = strings.Split(config.excludeDomains, ",")
+	}
+	var lazyAttrs []string
+	if config.lazyAttrs != "" {
+		lazyAttrs = strings.Split(config.lazyAttrs, ",")
+	}
+
+	fileNames, err := mirror.ParseFileNameRules(config.restrictFileNames)
+	if err != nil {
+		return fmt.Errorf("invalid --restrict-file-names: %v", err)
+	}
+
+	switch config.dedupe {
+	case "", "hardlink", "symlink":
+	default:
+		return fmt.Errorf("invalid --dedupe: %q is not hardlink or symlink", config.dedupe)
+	}
+
+	crawlOrder := config.crawlOrder
+	switch crawlOrder {
+	case "fifo":
+		crawlOrder = ""
+	case "html-first", "depth":
+	default:
+		return fmt.Errorf("invalid --crawl-order: %q is not fifo, html-first or depth", config.crawlOrder)
+	}
+
+	var saveHeaders string
+	switch {
+	case config.saveHeaders && config.headersSidecar:
+		return fmt.Errorf("--save-headers and --headers-sidecar can't be combined")
+	case config.saveHeaders && config.dedupe != "":
+		// Linked copies would get every URL's headers
+		return fmt.Errorf("--save-headers can't be combined with --dedupe")
+	case config.saveHeaders:
+		saveHeaders = "prepend"
+	case config.headersSidecar:
+		saveHeaders = "sidecar"
+	}
+
+	client := config.client
+	if config.login != "" {
+		if client, err = login(config); err != nil {
+			return fmt.Errorf("login failed: %v", err)
+		}
+	}
+	if config.fromWARC != "" {
+		// Rebuild the mirror from the archive instead of the network
+		archive, err := mirror.OpenWARC(config.fromWARC)
+		if err != nil {
+			return fmt.Errorf("reading --from-warc: %v", err)
+		}
+		defer archive.Close()
+		client = &http.Client{Transport: archive}
+	}
+
+	var quota int64
+	if config.quota != "" {
+		if quota, err = parseSize(config.quota); err != nil {
+			return fmt.Errorf("invalid --quota: %v", err)
+		}
+	}
+
+	// Create mirror config
+	mirrorConfig := &mirror.Config{
+		URL:          rawURL,
+		RejectTypes:  rejectTypes,
+		AcceptTypes:  acceptTypes,
+		ExcludePaths: excludePaths,
+		IncludePaths: includePaths,
+		ConvertLinks: config.convertLinks || config.convertFileOnly,
+		OutputDir:    config.outputDir,
+		Workers:      config.mirrorWorkers,
+		PerHost:      config.perHost,
+		MaxDepth:     config.maxDepth,
+		NoParent:     config.noParent,
+		Client:       client,
+		Retry:        config.retry,
+
+		HostDelay:  config.wait,
+		HostJitter: config.waitJitter,
+
+		NoHostDirectories: config.noHostDirectories,
+		CutDirs:           config.cutDirs,
+		NoDirectories:     config.noDirectories,
+		AdjustExtension:   config.adjustExtension,
+		BackupConverted:   config.backupConverted,
+		ConvertFileOnly:   config.convertFileOnly,
+		FileNames:         fileNames,
+		Timestamping:      config.timestamping,
+
+		NoServerTimestamps: config.noServerTimestamps,
+
+		Resume:       config.continueMirror,
+		StateFile:    config.mirrorState,
+		VisitedStore: config.visitedStore,
+
+		ManifestFile: config.mirrorManifest,
+		SaveHeaders:  saveHeaders,
+
+		DeleteAfter: config.deleteAfter,
+		BrokenLinks: config.brokenLinks,
+		SummaryFile: config.summaryFile,
+		ListURLs:    config.listURLs,
+		Spider:      config.spider,
+		Dedupe:      config.dedupe,
+		MaxFileSize: config.maxBytes,
+		Quota:       quota,
+		CrawlOrder:  crawlOrder,
+
+		SlowThreshold: config.slowThreshold,
+
+		MaxResources: config.maxPages,
+		KeepFrontier: config.keepFrontier,
+
+		AcceptRegex:    acceptRegex,
+		RejectRegex:    rejectRegex,
+		Rewrites:       config.rewrites,
+		SortQuery:      config.sortQuery,
+		PageRequisites: config.pageRequisites,
+		IgnoreRobots:   !config.robots,
+		HonorNofollow:  config.robots && config.nofollow,
+		Sitemaps:       config.sitemaps,
+
+		RemoveUnaccepted: config.removeUnaccepted,
+		UserAgent:      config.userAgent,
+		Header:         config.headers,
+		SpanHosts:      config.spanHosts,
+		Domains:        domains,
+		ExcludeDomains: excludeDomains,
+
+		StrictArchive: config.strictArchive,
+		TranscodeUTF8: config.transcodeUTF8,
+
+		PageList:   pages,
+		DiffReport: config.diffReport,
+
+		FollowSelector: config.followSelector,
+		LazyAttrs:      lazyAttrs,
+	}
+	if config.warcFile != "" {
+		mirrorConfig.WARCFile = config.warcFile + ".warc.gz"
+		mirrorConfig.WARCCompress = !config.noWARCCompression
+		if config.noWARCCompression {
+			mirrorConfig.WARCFile = config.warcFile + ".warc"
+		}
+	}
+	if config.dnsCache != nil {
+		mirrorConfig.Prefetch = config.dnsCache.prefetch
+	}
+	// Mirror transfers share the --rate-limit budget
+	mirrorConfig.WrapBody = func(r io.Reader) io.Reader {
+		return newRateLimitedReader(r, bandwidth)
+	}
+	mirrorConfig.AfterDownload = func(resource mirror.Resource) {
+		stats.finished(nil)
+		if config.execCmd == "" || resource.NotModified {
+			return
+		}
+		saved := savedFile{path: resource.LocalPath, size: resource.Size}
+		if err := runHook(config.execCmd, resource.URL, saved); err != nil {
+			log.Println(err)
+		}
+	}
+	mirrorConfig.OnFailure = func(resource mirror.Resource, err error) {
+		stats.finished(err)
+	}
+
+	if config.archive != "" {
+		if _, err := archiveFormat(config.archive); err != nil {
+			return fmt.Errorf("invalid --archive: %v", err)
+		}
+	}
+	if config.archiveOnly {
+		if config.archive == "" {
+			return fmt.Errorf("--archive-only needs --archive")
+		}
+		// The tree is only kept until it is packed
+		tmp, err := os.MkdirTemp("", "wget-mirror-")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(tmp)
+		mirrorConfig.OutputDir = tmp
+	}
+
+	// Create mirror instance
+	m, err := mirror.New(mirrorConfig)
+	if err != nil {
+		return err
+	}
+
+	// Start mirroring
+	stats.mirror.Store(m)
+	err = m.Start()
+	stats.mirror.Store(nil)
+	usage.addWritten(m.BytesWritten())
+
+	if config.archive != "" {
+		fmt.Printf("Packing the mirror into %s\n", config.archive)
+		if aerr := writeArchive(mirrorConfig.OutputDir, config.archive, mirrorConfig.StateFile); aerr != nil && err == nil {
+			err = fmt.Errorf("writing --archive: %v", aerr)
+		}
+	}
+	return err
+}
+
+// downloadResult is the outcome of one URL in a run
+type downloadResult struct {
+	url  string
+	file savedFile
+	err  error
+}
+
+// reportResults prints a per-URL summary when more than one URL was
+// requested and returns the number of failures
+func reportResults(results []downloadResult) int {
+	failed := 0
+	for _, r := range results {
+		if r.err != nil {
+			failed++
+		}
+	}
+	if len(results) < 2 {
+		return failed
+	}
+
+	fmt.Printf("\nFINISHED --- %d of %d URLs downloaded\n", len(results)-failed, len(results))
+	for _, r := range results {
+		if r.err != nil {
+			fmt.Printf("  failed: %s (%v)\n", r.url, r.err)
+		}
+	}
+	return failed
+}
+
+// exitStatus picks the exit status for a run with failures: exitTooLarge
+// when every failure was a --max-filesize skip, 1 otherwise
+func exitStatus(results []downloadResult) int {
+	for _, r := range results {
+		if r.err != nil && !isTooLarge(r.err) {
+			return 1
+		}
+	}
+	return exitTooLarge
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		os.Exit(serveCommand(os.Args[2:]))
+	}
+
 	config := Config{}
 	
 	flag.StringVar(&config.outputFile, "O", "", "Output file name")
@@ -235,10 +1044,141 @@ func main() {
 	flag.StringVar(&config.inputFile, "i", "", "Input file containing URLs")
 	flag.BoolVar(&config.mirror, "mirror", false, "Mirror website")
 	flag.StringVar(&config.reject, "R", "", "Reject file types")
+	flag.StringVar(&config.accept, "A", "", "Comma-separated file types to keep when mirroring; pages are still crawled")
+	flag.BoolVar(&config.removeUnaccepted, "remove-unaccepted", false, "Delete crawled pages that -A does not accept")
+	flag.StringVar(&config.acceptRegex, "accept-regex", "", "When mirroring, only keep URLs matching this regular expression")
+	flag.StringVar(&config.rejectRegex, "reject-regex", "", "When mirroring, skip URLs matching this regular expression")
+	flag.StringVar(&config.include, "I", "", "Comma-separated directories to restrict mirroring to (wildcards allowed)")
+	flag.StringVar(&config.followSelector, "follow-selector", "", "When mirroring, only follow links inside elements matching this CSS selector, e.g. \"main a, .pagination a\"")
+	flag.StringVar(&config.lazyAttrs, "lazy-attrs", "data-src,data-srcset,data-original,data-lazy-src,data-lazy-srcset", "When mirroring, comma-separated attributes also holding image URLs, for lazily loaded images; those ending in srcset hold srcsets")
 	flag.StringVar(&config.exclude, "X", "", "Exclude directories")
 	flag.BoolVar(&config.convertLinks, "convert-links", false, "Convert links for offline viewing")
+	flag.IntVar(&config.mirrorWorkers, "mirror-workers", 4, "Resources to download at once when mirroring")
+	flag.DurationVar(&config.wait, "wait", 0, "When mirroring, wait this long between requests to the same host")
+	flag.DurationVar(&config.waitJitter, "wait-jitter", 0, "Add a random delay of up to this much to --wait")
+	flag.BoolVar(&config.noHostDirectories, "nH", false, "When mirroring, don't save files under a directory named after the host")
+	flag.IntVar(&config.cutDirs, "cut-dirs", 0, "When mirroring, leave this many leading URL directories out of saved paths")
+	flag.BoolVar(&config.noDirectories, "nd", false, "When mirroring, save every file in one directory")
+	flag.BoolVar(&config.adjustExtension, "E", false, "When mirroring, add .html or .css to HTML and CSS files whose URLs lack it")
+	flag.BoolVar(&config.backupConverted, "K", false, "Keep the original of each page as <file>.orig before converting its links")
+	flag.BoolVar(&config.convertFileOnly, "convert-file-only", false, "Convert only the file name part of links, for mirrors served by a web server (implies --convert-links)")
+	flag.StringVar(&config.restrictFileNames, "restrict-file-names", "unix", "Characters to escape in mirrored file names: unix, windows, nocontrol, ascii, lowercase or uppercase, comma separated")
+	flag.BoolVar(&config.timestamping, "N", false, "When mirroring, only download resources changed since the local copy (use -K along with -k)")
+	flag.BoolVar(&config.noServerTimestamps, "no-use-server-timestamps", false, "Don't date saved files by the server's Last-Modified header")
+	flag.BoolVar(&config.continueMirror, "continue-mirror", false, "Resume an interrupted mirror from its saved crawl state")
+	flag.StringVar(&config.mirrorState, "mirror-state", "", "File to save the mirror's crawl state in (default .wget-mirror-state.json in the output directory)")
+	flag.StringVar(&config.visitedStore, "visited-store", "", "When mirroring, keep the set of URLs already seen in this file rather than in memory, for crawls of millions of URLs")
+	flag.BoolVar(&config.deleteAfter, "delete-after", false, "Delete each file once it has been downloaded (and, when mirroring, parsed for links)")
+	flag.StringVar(&config.brokenLinks, "broken-links", "", "When mirroring, write failed URLs and the pages linking to them to this file (JSON if it ends in .json)")
+	flag.StringVar(&config.summaryFile, "mirror-summary", "", "When mirroring, also write the run's totals to this file as JSON")
+	flag.BoolVar(&config.saveHeaders, "save-headers", false, "When mirroring, save each response's status line and headers before its body")
+	flag.BoolVar(&config.headersSidecar, "headers-sidecar", false, "When mirroring, save each response's status line and headers to FILE.headers next to it")
+	flag.StringVar(&config.mirrorManifest, "mirror-manifest", "", "When mirroring, write the URL, local path, status, size, SHA-256, type, depth and referrer of every resource to this file as JSON")
+	flag.BoolVar(&config.listURLs, "list-urls", false, "When mirroring, only list the URLs that would be fetched and where they would be saved; pages are fetched to find links but not kept")
+	flag.Var(&config.rewrites, "rewrite", "When mirroring, rewrite discovered URLs with a \"regex=>replacement\" rule (repeatable, applied in order)")
+	flag.BoolVar(&config.sortQuery, "sort-query", false, "When mirroring, treat URLs whose query parameters differ only in order as the same URL")
+	flag.StringVar(&config.warcFile, "warc-file", "", "When mirroring, also record every request and response in NAME.warc.gz; with --delete-after, only the WARC file is kept")
+	flag.StringVar(&config.archive, "archive", "", "When mirroring, also pack the mirrored files into this .tar.gz, .tgz or .zip file")
+	flag.BoolVar(&config.archiveOnly, "archive-only", false, "Keep only the --archive file, not the mirrored directory tree")
+	flag.StringVar(&config.fromWARC, "from-warc", "", "Mirror from the responses captured in this WARC file instead of the network; implies --mirror")
+	flag.BoolVar(&config.noWARCCompression, "no-warc-compression", false, "Write --warc-file uncompressed, as NAME.warc")
+	flag.StringVar(&config.login, "login", "", "When mirroring, log in first by POSTing --login-data to this form URL, or run the requests listed in @file (\"METHOD URL [BODY]\" per line), keeping the session cookies")
+	flag.StringVar(&config.loginData, "login-data", "", "Form data for --login, e.g. \"user=me&password=secret\"")
+	flag.IntVar(&config.maxPages, "max-pages", 0, "When mirroring, fetch at most this many files per run (0 for no limit)")
+	flag.BoolVar(&config.keepFrontier, "keep-frontier", false, "When --max-pages stops a mirror, keep its state so --continue-mirror fetches the rest")
+	flag.StringVar(&config.quota, "Q", "", "When mirroring, stop queueing new files once this much has been downloaded (e.g. 500M, 2G)")
+	flag.StringVar(&config.crawlOrder, "crawl-order", "fifo", "When mirroring, the order to fetch queued resources in: fifo, html-first (pages before other files) or depth (breadth-first by link depth)")
+	flag.StringVar(&config.dedupe, "dedupe", "", "When mirroring, replace files identical to one already saved with a link to it: hardlink or symlink")
+	flag.BoolVar(&config.noParent, "no-parent", false, "Never ascend above the starting URL's directory when mirroring")
+	flag.BoolVar(&config.pageRequisites, "page-requisites", false, "Fetch the images, CSS, scripts and fonts pages need, even off-host or past -l")
+	flag.BoolVar(&config.robots, "robots", true, "Honor robots.txt when mirroring (turn off with -e robots=off)")
+	flag.BoolVar(&config.nofollow, "nofollow", true, "Skip rel=nofollow links and honor robots meta tags when mirroring (turn off with -e nofollow=off; robots=off does too)")
+	flag.BoolVar(&config.sitemaps, "sitemaps", false, "When mirroring, also queue the pages listed in the site's sitemap.xml")
+	flag.Var(&config.commands, "e", "Run a wgetrc-style command, e.g. -e robots=off (repeatable)")
+	flag.BoolVar(&config.spanHosts, "H", false, "Follow links to other hosts when mirroring")
+	flag.StringVar(&config.domains, "D", "", "Comma-separated domains -H may span to (default any)")
+	flag.StringVar(&config.excludeDomains, "exclude-domains", "", "Comma-separated domains -H must not span to")
+	flag.StringVar(&config.level, "l", "inf", "Maximum mirror recursion depth (0 or inf for unlimited)")
+	flag.StringVar(&config.userAgent, "U", "", "User-Agent header to send")
+	flag.IntVar(&config.tries, "t", 1, "Number of tries per download")
+	flag.StringVar(&config.proxy, "proxy", "", "Proxy URL (overrides HTTP_PROXY/HTTPS_PROXY)")
+	flag.StringVar(&config.configFile, "config", "", "Startup file to read instead of ~/.wgetrc")
+	flag.BoolVar(&config.reportUsage, "report-usage", false, "Report CPU, memory, connection and disk usage at the end of the run")
+	flag.StringVar(&config.metricsAddr, "metrics-addr", "", "Serve the run's resource usage at http://ADDR/metrics while it runs (e.g. localhost:9100)")
+	flag.BoolVar(&config.dnsPrefetch, "dns-prefetch", false, "Resolve hostnames of queued URLs in the background")
+	flag.BoolVar(&config.expand, "expand", false, "Expand {1..10}, {001..100}, {a..z} and {x,y} patterns in URLs")
+	flag.BoolVar(&config.spider, "spider", false, "Check that URLs exist without saving them; with --mirror, check every link of the site and report dead links and redirects")
+	flag.DurationVar(&config.slowThreshold, "slow-threshold", 0, "In spider mode, flag responses slower than this (e.g. 2s)")
+	flag.BoolVar(&config.blockPrivate, "block-private", false, "Refuse to connect to private, loopback and link-local addresses (for crawling untrusted sites)")
+	flag.StringVar(&config.allowNet, "allow-net", "", "Comma-separated CIDRs still reachable with --block-private")
+	flag.Var((*headerFlag)(&config.headers), "header", "Extra request header \"Name: value\" (repeatable)")
+	flag.IntVar(&config.concurrency, "concurrency", 0, "Maximum simultaneous downloads for -i and --manifest (0 = all at once)")
+	flag.IntVar(&config.perHost, "per-host-concurrency", 4, "Maximum simultaneous downloads from one host for -i, --manifest and --mirror (0 = no limit)")
+	flag.StringVar(&config.manifest, "manifest", "", "JSON or YAML manifest describing the downloads to run")
+	flag.BoolVar(&config.feed, "feed", false, "Treat each URL as an RSS or Atom feed and download the files its items enclose")
+	flag.StringVar(&config.feedName, "feed-name", "{name}", "With --feed, how to name each file; {feed}, {title}, {date}, {name} and {ext} are filled in, and / makes directories")
+	flag.StringVar(&config.feedHistory, "feed-history", "", "With --feed, file listing what was already downloaded, so it is skipped (default .wget-feed-history under -P)")
+	flag.StringVar(&config.resultFile, "manifest-result", "", "Write a JSON manifest of per-download results to this file")
+	flag.BoolVar(&config.transcodeUTF8, "transcode-utf8", false, "When mirroring, re-encode saved HTML, CSS and JavaScript as UTF-8")
+	flag.StringVar(&config.execCmd, "exec", "", "Command to run after each download; {file}, {url} and {size} are substituted")
+	flag.StringVar(&config.notifyWebhook, "notify-webhook", "", "POST a JSON status payload to this URL when each job finishes")
+	flag.BoolVar(&config.notifyDesktop, "notify-desktop", false, "Show a desktop notification when each job finishes")
+	flag.StringVar(&config.fromURLList, "from-url-list", "", "Archive the pages listed in this file with their requisites, without following links")
+	flag.StringVar(&config.writeChecksum, "write-checksum", "", "Write a <file>.<digest> sidecar (md5, sha1, sha256, sha512)")
+	flag.DurationVar(&config.checkpointInterval, "checkpoint-interval", 0, "Log a progress summary this often (e.g. 10m)")
+	flag.BoolVar(&config.rotateLog, "rotate-log", false, "Rotate wget-log at each checkpoint (with -B)")
+	flag.StringVar(&config.keyring, "keyring", "", "Verify each download's detached GPG signature against this keyring")
+	flag.StringVar(&config.signatureURL, "signature-url", "", "Signature location; {url} is the download URL (default \"{url}.sig\")")
+	flag.StringVar(&config.quarantineDir, "quarantine-dir", "", "Move files that fail signature verification here instead of deleting them")
+	flag.StringVar(&config.diffReport, "diff-report", "", "When re-mirroring, append text diffs of changed HTML pages to this file")
+	flag.StringVar(&config.retryOn, "retry-on", "", "Comma-separated status codes to retry (default 408, 429 and 5xx)")
+	flag.DurationVar(&config.backoff, "backoff", 0, "Delay before the first retry, doubling each time (default 1s)")
+	flag.DurationVar(&config.maxBackoff, "max-backoff", 0, "Maximum delay between retries (default 10s)")
+	flag.DurationVar(&config.attemptTimeout, "attempt-timeout", 0, "Deadline for each download attempt")
+	flag.Var(&config.retryHosts, "retry-host", "Per-domain retry override, e.g. \"example.com tries=5 backoff=2s on=429,503\" (repeatable)")
+	flag.BoolVar(&config.preflight, "preflight", false, "Send a HEAD request first to report the size and type of each download")
+	flag.StringVar(&config.maxFilesize, "max-filesize", "", "Skip downloads, and files when mirroring, larger than this (e.g. 500M, 2G); implies --preflight")
+	flag.BoolVar(&config.noTypeExtension, "no-type-extension", false, "Don't add an extension from the Content-Type to file names that lack one")
+	flag.IntVar(&config.maxIdlePerHost, "max-idle-per-host", 8, "Idle keep-alive connections to keep per host")
+	flag.IntVar(&config.maxConnsPerHost, "max-conns-per-host", 0, "Maximum connections per host, including active ones (0 = no limit)")
+	flag.DurationVar(&config.idleTimeout, "idle-timeout", 90*time.Second, "Close keep-alive connections idle for longer than this")
+	flag.StringVar(&config.bufferSize, "buffer-size", "", "Copy buffer size for writing downloads (default 128k)")
+	flag.StringVar(&config.startAt, "start-at", "", "Wait until this time (HH:MM or \"YYYY-MM-DD HH:MM\") before starting")
+	flag.DurationVar(&config.watch, "watch", 0, "Poll the URLs this often and download each new version (runs until interrupted)")
+	flag.StringVar(&config.rateSchedule, "rate-schedule", "", "Time-of-day rate limits, e.g. \"08:00-18:00=500k,18:00-08:00=0\"; --rate-limit applies outside them")
+	flag.StringVar(&config.unixSocket, "unix-socket", "", "Send all requests to this Unix socket instead of the URL's host (e.g. /var/run/docker.sock)")
+	flag.DurationVar(&config.connectTimeout, "connect-timeout", 30*time.Second, "Give up on establishing a connection after this long")
+	flag.DurationVar(&config.keepAlive, "keepalive", 30*time.Second, "Interval between TCP keep-alive probes (negative disables them)")
+	flag.DurationVar(&config.fallbackDelay, "fallback-delay", 0, "How long to wait for IPv6 before also trying IPv4 (default 300ms, negative disables)")
+	flag.DurationVar(&config.readTimeout, "read-timeout", 0, "Abort and retry a transfer that receives nothing for this long (e.g. 30s)")
+	flag.StringVar(&config.minSpeed, "min-speed", "", "Abort and retry a transfer slower than this (e.g. 10k) for --min-speed-time")
+	flag.DurationVar(&config.minSpeedTime, "min-speed-time", 30*time.Second, "How long a transfer may stay below --min-speed")
+	flag.BoolVar(&config.continueDownload, "c", false, "Continue a partially downloaded file")
+	flag.BoolVar(&config.strictArchive, "strict-archive", false, "Fail the run if any page requisite is missing or a soft 404 is detected")
+	flag.StringVar(&config.controlSocket, "control-socket", "", "Unix socket accepting runtime commands such as \"rate 200k\"")
+	registerAliases(flag.CommandLine)
 	
-	flag.Parse()
+	flag.CommandLine.Parse(normalizeArgs(flag.CommandLine, os.Args[1:]))
+
+	if err := applyCommands(flag.CommandLine, config.commands); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Command-line flags take precedence over WGET_* variables, which in
+	// turn take precedence over the startup file
+	if err := loadEnv(flag.CommandLine); err != nil {
+		fmt.Printf("Error reading environment: %v\n", err)
+		os.Exit(1)
+	}
+	wgetrc, explicit := config.configFile, true
+	if wgetrc == "" {
+		wgetrc, explicit = defaultWgetrcPath(), false
+	}
+	if err := loadWgetrc(flag.CommandLine, wgetrc, explicit); err != nil {
+		fmt.Printf("Error reading config file: %v\n", err)
+		os.Exit(1)
+	}
 	
 	// Parse rate limit
 	if config.rateLimit != "" {
@@ -249,9 +1189,84 @@ func main() {
 		}
 		config.rateBytes = rateBytes
 	}
+	bandwidth.SetRate(config.rateBytes)
+	if config.rateSchedule != "" {
+		windows, err := parseRateSchedule(config.rateSchedule)
+		if err != nil {
+			fmt.Printf("Error parsing rate schedule: %v\n", err)
+			os.Exit(1)
+		}
+		startRateSchedule(windows, config.rateBytes)
+	}
+
+	maxDepth, err := parseLevel(config.level)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	config.maxDepth = maxDepth
+
+	if config.bufferSize != "" {
+		size, err := parseSize(config.bufferSize)
+		if err != nil || size <= 0 {
+			fmt.Printf("Error parsing --buffer-size: %q\n", config.bufferSize)
+			os.Exit(1)
+		}
+		copyBuffers = newBufferPool(int(size))
+	}
+
+	if config.minSpeed != "" {
+		minSpeed, err := parseRateLimit(config.minSpeed)
+		if err != nil {
+			fmt.Printf("Error parsing --min-speed: %v\n", err)
+			os.Exit(1)
+		}
+		config.minSpeedBytes = minSpeed
+	}
+
+	if config.maxFilesize != "" {
+		maxBytes, err := parseSize(config.maxFilesize)
+		if err != nil {
+			fmt.Printf("Error parsing --max-filesize: %v\n", err)
+			os.Exit(1)
+		}
+		config.maxBytes = maxBytes
+	}
+
+	if config.controlSocket != "" {
+		if err := serveControl(config.controlSocket); err != nil {
+			fmt.Printf("Error opening control socket: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if config.metricsAddr != "" {
+		if err := serveMetrics(config.metricsAddr); err != nil {
+			fmt.Printf("Error opening metrics endpoint: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if config.dnsPrefetch {
+		config.dnsCache = newDNSCache(5 * time.Minute)
+	}
+
+	retry, err := buildRetryRules(config)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	config.retry = retry
+
+	client, err := newHTTPClient(config)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	config.client = client
 
 	if config.background {
-		logFile, err := os.Create("wget-log")
+		logFile, err = os.Create("wget-log")
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -260,55 +1275,118 @@ func main() {
 		fmt.Println("Output will be written to \"wget-log\".")
 	}
 
+	if config.startAt != "" {
+		start, err := parseStartAt(config.startAt, time.Now())
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		waitUntil(start)
+	}
+
+	if config.checkpointInterval > 0 {
+		startCheckpoints(config.checkpointInterval, config.rotateLog)
+	}
+
+	if config.fromWARC != "" {
+		config.mirror = true
+	}
+
 	args := flag.Args()
-	if len(args) == 0 && config.inputFile == "" {
+	if config.fromURLList != "" {
+		err := mirrorPageList(config.fromURLList, config)
+		if config.reportUsage {
+			usage.report(os.Stdout)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if len(args) == 0 && config.inputFile == "" && config.manifest == "" {
 		fmt.Println("Please provide a URL or use -i flag with an input file")
 		os.Exit(1)
 	}
+	if config.expand {
+		if args, err = expandURLs(args); err != nil {
+			fmt.Printf("Error expanding URLs: %v\n", err)
+			os.Exit(1)
+		}
+	}
 
-	if config.mirror {
-		// Convert reject and exclude flags to slices
-		rejectTypes := []string{}
-		if config.reject != "" {
-			rejectTypes = strings.Split(config.reject, ",")
-		}
-		
-		excludePaths := []string{}
-		if config.exclude != "" {
-			excludePaths = strings.Split(config.exclude, ",")
-		}
-		
-		// Create mirror config
-		mirrorConfig := &mirror.Config{
-			URL:          args[0],
-			RejectTypes:  rejectTypes,
-			ExcludePaths: excludePaths,
-			ConvertLinks: config.convertLinks,
-			OutputDir:    config.outputDir,
-		}
-		
-		// Create mirror instance
-		m, err := mirror.New(mirrorConfig)
-		if err != nil {
-			log.Fatal(err)
+	if config.watch > 0 {
+		if len(args) == 0 || config.mirror || config.spider || config.deleteAfter {
+			fmt.Println("--watch needs URLs on the command line and can't be combined with --mirror, --spider or --delete-after")
+			os.Exit(1)
 		}
-		
-		// Start mirroring
-		if err := m.Start(); err != nil {
-			log.Fatal(err)
+		watchURLs(args, config.watch, config)
+	}
+
+	if config.spider && !config.mirror {
+		urls := args
+		if config.inputFile != "" {
+			jobs, err := readInputFile(config.inputFile, config)
+			if err != nil {
+				log.Fatal(err)
+			}
+			for _, job := range jobs {
+				urls = append(urls, job.url)
+			}
+		}
+		for _, r := range spiderURLs(urls, config) {
+			if r.err != nil {
+				os.Exit(1)
+			}
 		}
-		
 		return
 	}
 
+	var results []downloadResult
+	if config.manifest != "" {
+		batch, err := runManifest(config.manifest, config)
+		if err != nil {
+			log.Fatal(err)
+		}
+		results = append(results, batch...)
+	}
 	if config.inputFile != "" {
-		if err := downloadMultipleFiles(config.inputFile, config); err != nil {
+		batch, err := downloadMultipleFiles(config.inputFile, config)
+		if err != nil {
 			log.Fatal(err)
 		}
-		return
+		results = append(results, batch...)
+	}
+	if config.feed {
+		if config.mirror {
+			fmt.Println("--feed can't be combined with --mirror")
+			os.Exit(1)
+		}
+		results = append(results, runFeeds(args, config)...)
+		args = nil
+	}
+	for _, rawURL := range args {
+		var saved savedFile
+		var err error
+		if config.mirror {
+			err = mirrorSite(rawURL, config)
+		} else {
+			saved, err = downloadFile(rawURL, config)
+		}
+		if err != nil {
+			log.Printf("Error downloading %s: %v\n", rawURL, err)
+		}
+		result := downloadResult{url: rawURL, file: saved, err: err}
+		stats.finished(err)
+		notifyResult(config, result)
+		results = append(results, result)
 	}
 
-	if err := downloadFile(args[0], config); err != nil {
-		log.Fatal(err)
+	failed := reportResults(results)
+	if config.reportUsage {
+		usage.report(os.Stdout)
+	}
+	if failed > 0 {
+		os.Exit(exitStatus(results))
 	}
 }
@@ -0,0 +1,21 @@
+package main
+
+import "testing"
+
+func TestDefaultFileName(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://example.com", "index.html"},
+		{"https://example.com/", "index.html"},
+		{"https://example.com/docs/", "index.html"},
+		{"https://example.com/docs/guide.pdf", "guide.pdf"},
+		{"https://example.com/docs", "docs"},
+	}
+	for _, tt := range tests {
+		if got := defaultFileName(tt.url); got != tt.want {
+			t.Errorf("defaultFileName(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// manifestFile is a structured list of downloads, in JSON or, for files
+// ending in .yaml or .yml, the same fields in YAML:
+//
+//	{
+//	  "concurrency": 4,
+//	  "defaults": {"dir": "artifacts", "retry": {"tries": 3, "backoff": "2s"}},
+//	  "downloads": [
+//	    {"url": "https://example.com/a.tar.gz", "out": "a.tgz",
+//	     "headers": {"Authorization": "Bearer ..."},
+//	     "checksum": "sha-256=8f1c...",
+//	     "retry": {"tries": 5, "retry_on": "429,503", "timeout": "30s"}}
+//	  ]
+//	}
+type manifestFile struct {
+	Concurrency int             `json:"concurrency" yaml:"concurrency"`
+	Defaults    manifestEntry   `json:"defaults" yaml:"defaults"`
+	Downloads   []manifestEntry `json:"downloads" yaml:"downloads"`
+}
+
+// manifestEntry describes one download, or the defaults for all of them
+type manifestEntry struct {
+	URL      string            `json:"url" yaml:"url"`
+	Out      string            `json:"out" yaml:"out"`
+	Dir      string            `json:"dir" yaml:"dir"`
+	Headers  map[string]string `json:"headers" yaml:"headers"`
+	Checksum string            `json:"checksum" yaml:"checksum"`
+	Retry    *manifestRetry    `json:"retry" yaml:"retry"`
+}
+
+// manifestRetry is the retry policy of a download, the manifest's
+// counterpart of --tries, --backoff, --max-backoff, --retry-on and
+// --attempt-timeout. Durations are written like "1m30s".
+type manifestRetry struct {
+	Tries      int    `json:"tries" yaml:"tries"`
+	Backoff    string `json:"backoff" yaml:"backoff"`
+	MaxBackoff string `json:"max_backoff" yaml:"max_backoff"`
+	RetryOn    string `json:"retry_on" yaml:"retry_on"`
+	Timeout    string `json:"timeout" yaml:"timeout"`
+}
+
+// manifestResult is one entry of the result manifest
+type manifestResult struct {
+	URL    string `json:"url"`
+	File   string `json:"file,omitempty"`
+	Size   int64  `json:"size,omitempty"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// loadManifest reads a JSON or YAML manifest and turns it into download
+// jobs
+func loadManifest(path string, base Config) ([]downloadJob, int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	var m manifestFile
+	if ext := strings.ToLower(filepath.Ext(path)); ext == ".yaml" || ext == ".yml" {
+		err = yaml.Unmarshal(data, &m)
+	} else {
+		err = json.Unmarshal(data, &m)
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("%s: %v", path, err)
+	}
+
+	defaults := base
+	if err := applyManifestEntry(&defaults, base, m.Defaults); err != nil {
+		return nil, 0, fmt.Errorf("%s: defaults: %v", path, err)
+	}
+
+	jobs := make([]downloadJob, 0, len(m.Downloads))
+	for i, entry := range m.Downloads {
+		if entry.URL == "" {
+			return nil, 0, fmt.Errorf("%s: download %d has no url", path, i+1)
+		}
+		config := defaults
+		if err := applyManifestEntry(&config, defaults, entry); err != nil {
+			return nil, 0, fmt.Errorf("%s: %s: %v", path, entry.URL, err)
+		}
+		jobs = append(jobs, downloadJob{url: entry.URL, config: config})
+	}
+	return jobs, m.Concurrency, nil
+}
+
+// applyManifestEntry layers the options set in entry onto config
+func applyManifestEntry(config *Config, base Config, entry manifestEntry) error {
+	if entry.Out != "" {
+		config.outputFile = entry.Out
+	}
+	if entry.Dir != "" {
+		if err := setJobOption(config, base, "dir", entry.Dir); err != nil {
+			return err
+		}
+	}
+	if entry.Checksum != "" {
+		if err := setJobOption(config, base, "checksum", entry.Checksum); err != nil {
+			return err
+		}
+	}
+	if len(entry.Headers) > 0 {
+		headers := config.headers.Clone()
+		if headers == nil {
+			headers = http.Header{}
+		}
+		for name, value := range entry.Headers {
+			headers.Set(name, value)
+		}
+		config.headers = headers
+	}
+	if entry.Retry != nil {
+		if err := applyManifestRetry(config, *entry.Retry); err != nil {
+			return fmt.Errorf("retry: %v", err)
+		}
+	}
+	return nil
+}
+
+// applyManifestRetry sets the retry options in r on config and rebuilds
+// its retry rules from them
+func applyManifestRetry(config *Config, r manifestRetry) error {
+	if r.Tries > 0 {
+		config.tries = r.Tries
+	}
+	if r.RetryOn != "" {
+		config.retryOn = r.RetryOn
+	}
+	durations := []struct {
+		name  string
+		value string
+		dst   *time.Duration
+	}{
+		{"backoff", r.Backoff, &config.backoff},
+		{"max_backoff", r.MaxBackoff, &config.maxBackoff},
+		{"timeout", r.Timeout, &config.attemptTimeout},
+	}
+	for _, d := range durations {
+		if d.value == "" {
+			continue
+		}
+		value, err := time.ParseDuration(d.value)
+		if err != nil {
+			return fmt.Errorf("%s: %v", d.name, err)
+		}
+		*d.dst = value
+	}
+
+	rules, err := buildRetryRules(*config)
+	if err != nil {
+		return err
+	}
+	config.retry = rules
+	return nil
+}
+
+// runManifest executes every download in the manifest at path and, if
+// --manifest-result is set, writes the outcome of each one
+func runManifest(path string, config Config) ([]downloadResult, error) {
+	jobs, concurrency, err := loadManifest(path, config)
+	if err != nil {
+		return nil, err
+	}
+	if config.concurrency > 0 {
+		concurrency = config.concurrency
+	}
+
+	results := runJobs(jobs, concurrency, config.perHost)
+	if config.resultFile != "" {
+		if err := writeManifestResults(config.resultFile, results); err != nil {
+			return results, err
+		}
+	}
+	return results, nil
+}
+
+// writeManifestResults writes results as a JSON array
+func writeManifestResults(path string, results []downloadResult) error {
+	out := make([]manifestResult, 0, len(results))
+	for _, r := range results {
+		entry := manifestResult{URL: r.url, Status: "ok"}
+		if r.err != nil {
+			entry.Status = "failed"
+			entry.Error = r.err.Error()
+		} else {
+			entry.File = r.file.path
+			entry.Size = r.file.size
+		}
+		out = append(out, entry)
+	}
+
+	data, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
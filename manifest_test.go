@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"wget/mirror"
+)
+
+func writeManifest(t *testing.T, name, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadManifest(t *testing.T) {
+	path := writeManifest(t, "downloads.json", `{
+  "concurrency": 3,
+  "defaults": {"dir": "artifacts", "headers": {"X-Team": "build"}, "retry": {"tries": 4}},
+  "downloads": [
+    {"url": "http://example.com/a.tgz"},
+    {"url": "http://example.com/b.tgz", "out": "b-latest.tgz", "dir": "b",
+     "headers": {"Authorization": "Bearer t"}, "retry": {"tries": 1},
+     "checksum": "sha256=00ff"}
+  ]
+}`)
+	base := Config{outputDir: "out", tries: 2, headers: http.Header{"Accept": {"*/*"}}}
+	jobs, concurrency, err := loadManifest(path, base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if concurrency != 3 || len(jobs) != 2 {
+		t.Fatalf("loadManifest = %d jobs, concurrency %d", len(jobs), concurrency)
+	}
+
+	tests := []struct {
+		name      string
+		got, want any
+	}{
+		{"defaults dir", jobs[0].config.outputDir, filepath.Join("out", "artifacts")},
+		{"entry dir", jobs[1].config.outputDir, filepath.Join("out", "artifacts", "b")},
+		{"default out", jobs[0].config.outputFile, ""},
+		{"entry out", jobs[1].config.outputFile, "b-latest.tgz"},
+		{"default tries", jobs[0].config.tries, 4},
+		{"entry tries", jobs[1].config.tries, 1},
+		{"base header", jobs[1].config.headers.Get("Accept"), "*/*"},
+		{"default header", jobs[1].config.headers.Get("X-Team"), "build"},
+		{"entry header", jobs[1].config.headers.Get("Authorization"), "Bearer t"},
+		{"entry header on another entry", jobs[0].config.headers.Get("Authorization"), ""},
+		{"checksum", jobs[1].config.checksum, "00ff"},
+		{"base headers untouched", base.headers.Get("X-Team"), ""},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestLoadManifestYAML(t *testing.T) {
+	path := writeManifest(t, "downloads.yaml", `
+concurrency: 2
+defaults:
+  retry: {tries: 3, backoff: 2s}
+downloads:
+  - url: http://example.com/a.tgz
+    headers:
+      Authorization: Bearer t
+  - url: http://example.com/b.tgz
+    retry:
+      tries: 6
+      max_backoff: 1m
+      retry_on: 429,503
+      timeout: 30s
+`)
+	jobs, concurrency, err := loadManifest(path, Config{tries: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if concurrency != 2 || len(jobs) != 2 {
+		t.Fatalf("loadManifest = %d jobs, concurrency %d", len(jobs), concurrency)
+	}
+
+	a, b := jobs[0].config, jobs[1].config
+	policy := b.retry.For("example.com").(*mirror.RetryPolicy)
+	tests := []struct {
+		name      string
+		got, want any
+	}{
+		{"header", a.headers.Get("Authorization"), "Bearer t"},
+		{"default tries", a.tries, 3},
+		{"default rules tries", a.retry.For("example.com").(*mirror.RetryPolicy).Tries, 3},
+		{"default backoff", a.backoff, 2 * time.Second},
+		{"entry tries", policy.Tries, 6},
+		{"inherited backoff", policy.InitialBackoff, 2 * time.Second},
+		{"entry max backoff", policy.MaxBackoff, time.Minute},
+		{"entry timeout", policy.AttemptTimeout, 30 * time.Second},
+		{"entry retry on", fmt.Sprint(policy.RetryStatus), "[429 503]"},
+		{"default retry on", a.retryOn, ""},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestLoadManifestErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"not json", `downloads: []`},
+		{"no url", `{"downloads": [{"out": "a"}]}`},
+		{"bad checksum", `{"downloads": [{"url": "http://example.com/", "checksum": "00ff"}]}`},
+		{"bad default", `{"defaults": {"checksum": "nope=00"}, "downloads": []}`},
+		{"bad backoff", `{"downloads": [{"url": "http://example.com/", "retry": {"backoff": "soon"}}]}`},
+		{"bad retry on", `{"downloads": [{"url": "http://example.com/", "retry": {"retry_on": "teapot"}}]}`},
+	}
+	for _, tt := range tests {
+		if _, _, err := loadManifest(writeManifest(t, "m.json", tt.data), Config{}); err == nil {
+			t.Errorf("%s: loadManifest succeeds", tt.name)
+		}
+	}
+}
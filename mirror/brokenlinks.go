@@ -0,0 +1,106 @@
+package mirror
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// brokenLink is one entry of the broken link report
+type brokenLink struct {
+	URL       string   `json:"url"`
+	Status    int      `json:"status,omitempty"` // HTTP status; 0 if the request itself failed
+	Error     string   `json:"error"`
+	Transient bool     `json:"transient"` // Worth trying again later, unlike a 404
+	Referrers []string `json:"referrers"` // Pages linking to URL, up to maxReferrers of them
+}
+
+// maxReferrers is how many of the pages linking to one URL are kept. A
+// link on every page, like a menu entry, would otherwise keep every page.
+// Past maxReferred URLs, the pages linking to any more aren't kept at
+// all, so a crawl of millions of URLs doesn't keep millions of sets.
+const (
+	maxReferrers = 20
+	maxReferred  = 100000
+)
+
+// addReferrer notes that the page at page links to url
+func (q *Queue) addReferrer(url, page string) {
+	q.referrersLock.Lock()
+	defer q.referrersLock.Unlock()
+	pages := q.referrers[url]
+	if pages == nil {
+		if len(q.referrers) >= maxReferred {
+			return
+		}
+		pages = map[string]struct{}{}
+		q.referrers[url] = pages
+	}
+	if len(pages) < maxReferrers {
+		pages[page] = struct{}{}
+	}
+}
+
+// referrersOf returns the pages found linking to url, sorted
+func (q *Queue) referrersOf(url string) []string {
+	q.referrersLock.Lock()
+	defer q.referrersLock.Unlock()
+	pages := make([]string, 0, len(q.referrers[url]))
+	for page := range q.referrers[url] {
+		pages = append(pages, page)
+	}
+	sort.Strings(pages)
+	return pages
+}
+
+// brokenLinks lists every resource that failed, sorted by URL
+func (m *Mirror) brokenLinks() []brokenLink {
+	m.failuresLock.Lock()
+	defer m.failuresLock.Unlock()
+
+	links := make([]brokenLink, 0, len(m.failures))
+	for _, f := range m.failures {
+		link := brokenLink{
+			URL:       f.URL,
+			Error:     f.Err.Error(),
+			Transient: isTransient(f.Err),
+			Referrers: m.queue.referrersOf(f.URL),
+		}
+		var se *statusError
+		if errors.As(f.Err, &se) {
+			link.Status = se.code
+		}
+		links = append(links, link)
+	}
+	sort.Slice(links, func(i, j int) bool {
+		return links[i].URL < links[j].URL
+	})
+	return links
+}
+
+// writeBrokenLinks writes the broken link report to BrokenLinks, as JSON
+// if its name ends in .json and as text otherwise
+func (m *Mirror) writeBrokenLinks() error {
+	links := m.brokenLinks()
+
+	if strings.HasSuffix(strings.ToLower(m.config.BrokenLinks), ".json") {
+		data, err := json.MarshalIndent(links, "", "  ")
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(m.config.BrokenLinks, append(data, '\n'), 0644)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d broken links\n", len(links))
+	for _, link := range links {
+		fmt.Fprintf(&b, "\n%s (%s)\n", link.URL, link.Error)
+		for _, page := range link.Referrers {
+			fmt.Fprintf(&b, "    linked from %s\n", page)
+		}
+	}
+	return os.WriteFile(m.config.BrokenLinks, []byte(b.String()), 0644)
+}
@@ -0,0 +1,244 @@
+package mirror
+
+import (
+	"bytes"
+	"mime"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// windows1252 maps the 0x80-0x9F range of Windows-1252 to Unicode; the
+// rest of the code page matches ISO-8859-1
+var windows1252 = [32]rune{
+	'€', 0x81, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', 0x8D, 'Ž', 0x8F,
+	0x90, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', 0x9D, 'ž', 'Ÿ',
+}
+
+var (
+	metaCharsetRe   = regexp.MustCompile(`(?i)(<meta[^>]+charset\s*=\s*["']?)([\w.:-]+)`)
+	cssCharsetRe    = regexp.MustCompile(`(?i)^(\s*@charset\s+["'])([\w.:-]+)(["'])`)
+	metaSearchLimit = 1024
+
+	// Where a charset declaration goes in a page without one: inside
+	// <head>, else inside <html>, else after the doctype
+	declarationSpotRes = []*regexp.Regexp{
+		regexp.MustCompile(`(?i)<head(?:\s[^>]*)?>`),
+		regexp.MustCompile(`(?i)<html(?:\s[^>]*)?>`),
+		regexp.MustCompile(`(?i)<!doctype[^>]*>`),
+	}
+)
+
+// textKind classifies a resource as "html", "css", "js" or "" from its
+// Content-Type, falling back to the URL extension
+func textKind(resource *Resource) string {
+	mediaType, _, _ := mime.ParseMediaType(resource.ContentType)
+	switch mediaType {
+	case "text/html", "application/xhtml+xml":
+		return "html"
+	case "text/css":
+		return "css"
+	case "text/javascript", "application/javascript", "application/x-javascript":
+		return "js"
+	}
+
+	switch strings.ToLower(path.Ext(resource.LocalPath)) {
+	case ".html", ".htm":
+		return "html"
+	case ".css":
+		return "css"
+	case ".js", ".mjs":
+		return "js"
+	}
+	return ""
+}
+
+// detectCharset finds the declared charset of content: the Content-Type
+// header wins, then a byte order mark, then <meta> or @charset in the
+// document itself. It returns "" if nothing is declared.
+func detectCharset(contentType string, content []byte, kind string) string {
+	if _, params, err := mime.ParseMediaType(contentType); err == nil && params["charset"] != "" {
+		return strings.ToLower(params["charset"])
+	}
+	if bytes.HasPrefix(content, []byte("\xef\xbb\xbf")) {
+		return "utf-8"
+	}
+
+	head := content
+	if len(head) > metaSearchLimit {
+		head = head[:metaSearchLimit]
+	}
+	switch kind {
+	case "html":
+		if m := metaCharsetRe.FindSubmatch(head); m != nil {
+			return strings.ToLower(string(m[2]))
+		}
+	case "css":
+		if m := cssCharsetRe.FindSubmatch(head); m != nil {
+			return strings.ToLower(string(m[2]))
+		}
+	}
+	return ""
+}
+
+// decodeToUTF8 decodes content from a single-byte legacy charset. ok is
+// false if the charset is not one this package can decode.
+func decodeToUTF8(charset string, content []byte) (out []byte, ok bool) {
+	switch charset {
+	case "", "utf-8", "utf8", "us-ascii", "ascii":
+		return content, true
+	case "iso-8859-1", "latin1", "latin-1", "l1", "windows-1252", "cp1252", "x-cp1252":
+		// Browsers decode ISO-8859-1 labels as Windows-1252 too
+		return decodeSingleByte(content, &windows1252), true
+	}
+	return nil, false
+}
+
+// decodeSingleByte maps each byte to its code point, using high for the
+// 0x80-0x9F range
+func decodeSingleByte(content []byte, high *[32]rune) []byte {
+	out := make([]byte, 0, len(content)+len(content)/8)
+	for _, b := range content {
+		r := rune(b)
+		if b >= 0x80 && b <= 0x9F {
+			r = high[b-0x80]
+		}
+		out = utf8.AppendRune(out, r)
+	}
+	return out
+}
+
+// declareUTF8 rewrites the charset declaration inside the document. A
+// page that declares none, its charset having come from the Content-Type
+// header alone, gets <meta charset="utf-8"> so it still reads right from
+// disk.
+func declareUTF8(content []byte, kind string) []byte {
+	switch kind {
+	case "html":
+		if loc := metaCharsetRe.FindSubmatchIndex(content); loc != nil {
+			out := append([]byte{}, content[:loc[3]]...)
+			out = append(out, "utf-8"...)
+			return append(out, content[loc[1]:]...)
+		}
+		at := 0
+		for _, re := range declarationSpotRes {
+			if loc := re.FindIndex(content); loc != nil {
+				at = loc[1]
+				break
+			}
+		}
+		out := append([]byte{}, content[:at]...)
+		out = append(out, `<meta charset="utf-8">`...)
+		return append(out, content[at:]...)
+	case "css":
+		return cssCharsetRe.ReplaceAll(content, []byte("${1}utf-8${3}"))
+	}
+	return content
+}
+
+// decodeDocument returns content decoded to UTF-8 from the charset
+// declared for it, and that charset. ok is false, and content returned
+// as is, if the charset is not one this package can decode.
+func decodeDocument(contentType string, content []byte, kind string) (decoded []byte, charset string, ok bool) {
+	charset = detectCharset(contentType, content, kind)
+	decoded, ok = decodeToUTF8(charset, content)
+	if !ok {
+		return content, charset, false
+	}
+	return decoded, charset, true
+}
+
+// declareUTF8Node points the charset declaration of the parsed page doc
+// at UTF-8, adding <meta charset="utf-8"> to its head if it has none, so
+// the page still reads right without the Content-Type header it came
+// with
+func declareUTF8Node(doc *html.Node) {
+	var head *html.Node
+	found := false
+	var f func(*html.Node)
+	f = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch {
+			case n.DataAtom == atom.Head && head == nil:
+				head = n
+			case n.DataAtom == atom.Meta:
+				for i, a := range n.Attr {
+					switch {
+					case a.Key == "charset":
+						n.Attr[i].Val = "utf-8"
+						found = true
+					case a.Key == "content" && strings.EqualFold(attrValue(n, "http-equiv"), "content-type"):
+						n.Attr[i].Val = "text/html; charset=utf-8"
+						found = true
+					}
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			f(c)
+		}
+	}
+	f(doc)
+
+	if found || head == nil {
+		return
+	}
+	meta := &html.Node{
+		Type:     html.ElementNode,
+		DataAtom: atom.Meta,
+		Data:     "meta",
+		Attr:     []html.Attribute{{Key: "charset", Val: "utf-8"}},
+	}
+	head.InsertBefore(meta, head.FirstChild)
+}
+
+// transcodeToUTF8 rewrites a saved HTML, CSS or JavaScript file as UTF-8
+// and updates its charset declaration, and resource's Content-Type, to
+// match
+func transcodeToUTF8(resource *Resource) error {
+	kind := textKind(resource)
+	if kind == "" {
+		return nil
+	}
+
+	content, err := os.ReadFile(resource.LocalPath)
+	if err != nil {
+		return err
+	}
+	charset := detectCharset(resource.ContentType, content, kind)
+	decoded, ok := decodeToUTF8(charset, content)
+	if !ok {
+		return errUnsupportedCharset(charset)
+	}
+	if charset == "" || charset == "utf-8" || charset == "utf8" {
+		return nil
+	}
+
+	info, err := os.Stat(resource.LocalPath)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(resource.LocalPath, declareUTF8(decoded, kind), 0644); err != nil {
+		return err
+	}
+	if err := os.Chtimes(resource.LocalPath, info.ModTime(), info.ModTime()); err != nil {
+		return err
+	}
+	// Later steps decode the file by its Content-Type
+	if mediaType, params, err := mime.ParseMediaType(resource.ContentType); err == nil && params["charset"] != "" {
+		params["charset"] = "utf-8"
+		resource.ContentType = mime.FormatMediaType(mediaType, params)
+	}
+	return nil
+}
+
+type errUnsupportedCharset string
+
+func (e errUnsupportedCharset) Error() string {
+	return "cannot transcode from charset " + string(e)
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 
@@ -14,10 +15,11 @@ import (
 type Converter struct {
 	baseURL *url.URL
 	config  *Config
+	queue   *Queue
 }
 
 // NewConverter creates a new Converter instance
-func NewConverter(baseURL string, config *Config) (*Converter, error) {
+func NewConverter(baseURL string, config *Config, queue *Queue) (*Converter, error) {
 	parsedURL, err := url.Parse(baseURL)
 	if err != nil {
 		return nil, err
@@ -25,25 +27,63 @@ func NewConverter(baseURL string, config *Config) (*Converter, error) {
 	return &Converter{
 		baseURL: parsedURL,
 		config:  config,
+		queue:   queue,
 	}, nil
 }
 
-// ConvertLinks converts links in HTML files for offline viewing
-func (c *Converter) ConvertLinks(filePath string) error {
+// ConvertLinks converts links in the HTML file at filePath, fetched from
+// pageURL, for offline viewing
+func (c *Converter) ConvertLinks(filePath, pageURL string) error {
+	return c.convertPage(filePath, pageURL, "")
+}
+
+// convertPage is ConvertLinks for a page served with contentType. The
+// page is decoded from its charset before parsing and written back as
+// UTF-8, declared as such.
+func (c *Converter) convertPage(filePath, pageURL, contentType string) error {
+	page, err := url.Parse(pageURL)
+	if err != nil {
+		return err
+	}
+
 	// Read the file
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return err
+	}
 	content, err := os.ReadFile(filePath)
 	if err != nil {
 		return err
 	}
 
-	// Parse HTML
-	doc, err := html.Parse(bytes.NewReader(content))
+	// Parse HTML. A charset this package can't decode is left as it is.
+	decoded, charset, ok := decodeDocument(contentType, content, "html")
+	doc, err := html.Parse(bytes.NewReader(decoded))
 	if err != nil {
 		return err
 	}
+	if ok && charset != "" {
+		declareUTF8Node(doc)
+	}
+
+	// Keep the original alongside the converted file
+	if c.config.BackupConverted {
+		if err := c.writeFile(filePath+".orig", content, info); err != nil {
+			return err
+		}
+	}
+
+	// Convert links, resolving them as the browser would
+	c.convertNode(doc, filepath.Dir(filePath), documentBase(doc, page))
 
-	// Convert links
-	c.convertNode(doc, filepath.Dir(filePath))
+	// Converted links are relative to the saved page, so a <base> would
+	// send them back to the site. Links converted with ConvertFileOnly
+	// still resolve against it.
+	if !c.config.ConvertFileOnly {
+		if base := findBase(doc); base != nil {
+			removeAttr(base, "href")
+		}
+	}
 
 	// Write back to file
 	var buf bytes.Buffer
@@ -51,39 +91,153 @@ func (c *Converter) ConvertLinks(filePath string) error {
 		return err
 	}
 
-	return os.WriteFile(filePath, buf.Bytes(), 0644)
+	return c.writeFile(filePath, buf.Bytes(), info)
+}
+
+// ConvertCSSLinks converts the url() and @import references in the
+// stylesheet at filePath, fetched from sheetURL, for offline viewing
+func (c *Converter) ConvertCSSLinks(filePath, sheetURL string) error {
+	sheet, err := url.Parse(sheetURL)
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return err
+	}
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+
+	if c.config.BackupConverted {
+		if err := c.writeFile(filePath+".orig", content, info); err != nil {
+			return err
+		}
+	}
+
+	basePath := filepath.Dir(filePath)
+	converted := rewriteCSS(string(content), func(ref string) string {
+		if newPath := c.convertPath(ref, basePath, sheet); newPath != "" {
+			return newPath
+		}
+		return ref
+	})
+	return c.writeFile(filePath, []byte(converted), info)
+}
+
+// writeFile writes content to filePath. Unless files are dated when they
+// were downloaded, it keeps the modification time of the downloaded file,
+// info: the server's, which the next run sends as If-Modified-Since.
+func (c *Converter) writeFile(filePath string, content []byte, info os.FileInfo) error {
+	if err := os.WriteFile(filePath, content, 0644); err != nil {
+		return err
+	}
+	if c.config.Timestamping || !c.config.NoServerTimestamps {
+		return os.Chtimes(filePath, info.ModTime(), info.ModTime())
+	}
+	return nil
 }
 
 // convertNode recursively processes HTML nodes and converts links
-func (c *Converter) convertNode(n *html.Node, basePath string) {
+func (c *Converter) convertNode(n *html.Node, basePath string, page *url.URL) {
 	if n.Type == html.ElementNode {
-		var attr string
-		switch n.Data {
-		case "a", "link":
-			attr = "href"
-		case "img", "script":
-			attr = "src"
+		for _, attr := range urlAttrs(n) {
+			for i, a := range n.Attr {
+				if a.Key == attr {
+					if newPath := c.convertPath(a.Val, basePath, page); newPath != "" {
+						n.Attr[i].Val = newPath
+					}
+				}
+			}
 		}
 
-		if attr != "" {
+		if hasSrcset(n) {
 			for i, a := range n.Attr {
-				if a.Key == attr {
-					if newPath := c.convertPath(a.Val, basePath); newPath != "" {
+				if a.Key == "srcset" {
+					n.Attr[i].Val = c.convertSrcset(a.Val, basePath, page)
+				}
+			}
+		}
+
+		if n.Data == "meta" && strings.EqualFold(attrValue(n, "http-equiv"), "refresh") {
+			for i, a := range n.Attr {
+				if a.Key != "content" {
+					continue
+				}
+				if prefix, target := refreshTarget(a.Val); target != "" {
+					if newPath := c.convertPath(target, basePath, page); newPath != "" {
+						n.Attr[i].Val = prefix + newPath
+					}
+				}
+			}
+		}
+
+		for _, name := range c.config.LazyAttrs {
+			name = strings.ToLower(name)
+			for i, a := range n.Attr {
+				switch {
+				case a.Namespace != "" || a.Key != name:
+				case isSrcsetAttr(name):
+					n.Attr[i].Val = c.convertSrcset(a.Val, basePath, page)
+				default:
+					if newPath := c.convertPath(a.Val, basePath, page); newPath != "" {
 						n.Attr[i].Val = newPath
 					}
 				}
 			}
 		}
+
+		convert := func(ref string) string {
+			if newPath := c.convertPath(ref, basePath, page); newPath != "" {
+				return newPath
+			}
+			return ref
+		}
+		for i, a := range n.Attr {
+			if a.Key == "style" {
+				n.Attr[i].Val = rewriteCSS(a.Val, convert)
+			}
+		}
+		if n.Data == "style" {
+			for child := n.FirstChild; child != nil; child = child.NextSibling {
+				if child.Type == html.TextNode {
+					child.Data = rewriteCSS(child.Data, convert)
+				}
+			}
+		}
 	}
 
 	for child := n.FirstChild; child != nil; child = child.NextSibling {
-		c.convertNode(child, basePath)
+		c.convertNode(child, basePath, page)
+	}
+}
+
+// convertSrcset converts every candidate URL of a srcset attribute
+func (c *Converter) convertSrcset(srcset string, basePath string, page *url.URL) string {
+	candidates := parseSrcset(srcset)
+	entries := make([]string, 0, len(candidates))
+	for _, candidate := range candidates {
+		entry := candidate.url
+		if newPath := c.convertPath(candidate.url, basePath, page); newPath != "" {
+			entry = newPath
+		}
+		if candidate.descriptor != "" {
+			entry += " " + candidate.descriptor
+		}
+		entries = append(entries, entry)
 	}
+	return strings.Join(entries, ", ")
 }
 
-// convertPath converts a URL to a relative path for offline viewing
-func (c *Converter) convertPath(rawURL string, basePath string) string {
-	// Skip empty URLs, anchors, and absolute URLs to other domains
+// convertPath converts a URL to a path relative to the page saved in
+// basePath, keeping its #fragment. Links to resources that were not
+// downloaded are made absolute so they lead back to the live site.
+func (c *Converter) convertPath(rawURL string, basePath string, page *url.URL) string {
+	rawURL = trimURL(rawURL)
+
+	// Skip empty URLs and anchors
 	if rawURL == "" || strings.HasPrefix(rawURL, "#") {
 		return ""
 	}
@@ -92,16 +246,69 @@ func (c *Converter) convertPath(rawURL string, basePath string) string {
 	if err != nil {
 		return ""
 	}
+	abs := page.ResolveReference(u)
+	if abs.Scheme != "http" && abs.Scheme != "https" {
+		return ""
+	}
 
-	// Handle absolute URLs
-	if u.IsAbs() {
-		if u.Host != c.baseURL.Host {
-			return ""
+	// Resources are saved under their rewritten URL
+	target := abs
+	if len(c.config.Rewrites) > 0 {
+		unfragmented := *abs
+		unfragmented.Fragment = ""
+		if target = rewriteURL(c.config.Rewrites, &unfragmented); target == nil {
+			return abs.String()
 		}
-		// Convert to path relative to the base directory
-		return filepath.Join(c.config.OutputDir, u.Host, u.Path)
+		target.Fragment = abs.Fragment
 	}
 
-	// Handle relative URLs
-	return filepath.Join(basePath, u.Path)
+	saved, ok := c.savedPath(target)
+	if !ok {
+		return target.String()
+	}
+	if c.config.ConvertFileOnly {
+		return fileOnlyLink(u, saved)
+	}
+	link := relativeLink(basePath, saved)
+	if abs.Fragment != "" {
+		link += "#" + abs.Fragment
+	}
+	return link
+}
+
+// fileOnlyLink returns ref with only its file name replaced by that of
+// saved, where the resource it links to is saved. The rest of the link
+// is kept, so it still works once the mirror is served by a web server.
+func fileOnlyLink(ref *url.URL, saved string) string {
+	link := *ref
+	dir, _ := path.Split(ref.Path)
+	link.Path = dir + path.Base(saved)
+	link.RawPath = ""
+	link.RawQuery = ""
+	link.ForceQuery = false
+	return link.String()
+}
+
+// savedPath returns where the resource at u, or the one it redirects
+// to, was saved, and false if it was not downloaded
+func (c *Converter) savedPath(u *url.URL) (string, bool) {
+	key := normalizeURL(u, c.config.SortQuery).String()
+	c.queue.ProcessLock.RLock()
+	defer c.queue.ProcessLock.RUnlock()
+	saved, ok := c.queue.Saved[key]
+	if !ok && c.queue.Redirects[key] != "" {
+		saved, ok = c.queue.Saved[c.queue.Redirects[key]]
+	}
+	return saved, ok
+}
+
+// removeAttr deletes the attribute key from element n
+func removeAttr(n *html.Node, key string) {
+	attrs := n.Attr[:0]
+	for _, a := range n.Attr {
+		if a.Namespace != "" || a.Key != key {
+			attrs = append(attrs, a)
+		}
+	}
+	n.Attr = attrs
 }
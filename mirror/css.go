@@ -0,0 +1,71 @@
+package mirror
+
+import (
+	"io"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// cssURL matches url(...) references and @import strings in a stylesheet
+var cssURL = regexp.MustCompile(`url\(\s*['"]?([^'")]+?)['"]?\s*\)|@import\s+['"]([^'"]+)['"]`)
+
+// ParseCSS queues the fonts, images and imported stylesheets referenced
+// by the stylesheet fetched from cssURL, depth links away from the seed
+func (p *Parser) ParseCSS(r io.Reader, sheetURL string, depth int) error {
+	base, err := url.Parse(sheetURL)
+	if err != nil {
+		return err
+	}
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	for _, ref := range cssLinks(string(content)) {
+		p.processURL(ref, base, sheetURL, depth+1, true)
+	}
+	return nil
+}
+
+// cssLinks returns the URLs referenced by CSS text, skipping data: URLs
+func cssLinks(css string) []string {
+	var links []string
+	for _, match := range cssURL.FindAllStringSubmatch(css, -1) {
+		ref := match[1]
+		if ref == "" {
+			ref = match[2]
+		}
+		if !strings.HasPrefix(ref, "data:") {
+			links = append(links, ref)
+		}
+	}
+	return links
+}
+
+// rewriteCSS replaces each URL referenced by CSS text with convert(url),
+// leaving the surrounding url() or @import syntax untouched
+func rewriteCSS(css string, convert func(string) string) string {
+	var b strings.Builder
+	last := 0
+	for _, m := range cssURL.FindAllStringSubmatchIndex(css, -1) {
+		start, end := m[2], m[3]
+		if start < 0 {
+			start, end = m[4], m[5]
+		}
+		ref := css[start:end]
+		if strings.HasPrefix(ref, "data:") {
+			continue
+		}
+		b.WriteString(css[last:start])
+		b.WriteString(convert(ref))
+		last = end
+	}
+	b.WriteString(css[last:])
+	return b.String()
+}
+
+// isCSS reports whether a downloaded resource is a stylesheet
+func isCSS(resource Resource) bool {
+	return resource.IsCSS || strings.HasPrefix(resource.ContentType, "text/css")
+}
@@ -0,0 +1,70 @@
+package mirror
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// contentIndex maps the SHA-256 of each file saved to its path
+type contentIndex struct {
+	mu    sync.Mutex
+	paths map[string]string
+}
+
+// canonical returns the path first saved with the content resource has,
+// recording resource's path if it is the first
+func (c *contentIndex) canonical(resource Resource) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.paths == nil {
+		c.paths = map[string]string{}
+	}
+	if p, ok := c.paths[resource.SHA256]; ok {
+		return p
+	}
+	c.paths[resource.SHA256] = resource.LocalPath
+	return resource.LocalPath
+}
+
+// dedupe replaces resource's file with a link to an identical file saved
+// earlier, and has links to resource point at that file. Pages and
+// stylesheets are left alone, as converting their links rewrites them
+// for their own location, and nothing is kept to link to with
+// DeleteAfter. If linking fails the copy is kept.
+func (m *Mirror) dedupe(resource *Resource) {
+	if resource.SHA256 == "" || resource.IsHTML || isCSS(*resource) || m.config.DeleteAfter {
+		return
+	}
+	canonical := m.contents.canonical(*resource)
+	if canonical == resource.LocalPath {
+		return
+	}
+
+	tmp := resource.LocalPath + ".dedupe"
+	var err error
+	if m.config.Dedupe == "symlink" {
+		var target string
+		if target, err = filepath.Rel(filepath.Dir(resource.LocalPath), canonical); err == nil {
+			err = os.Symlink(target, tmp)
+		}
+	} else {
+		err = os.Link(canonical, tmp)
+	}
+	if err == nil {
+		err = os.Rename(tmp, resource.LocalPath)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		fmt.Printf("Error linking %s to %s: %v\n", resource.LocalPath, canonical, err)
+		return
+	}
+	resource.LocalPath = canonical
+
+	if m.keepsSaved() {
+		m.queue.ProcessLock.Lock()
+		m.queue.Saved[resource.URL] = canonical
+		m.queue.ProcessLock.Unlock()
+	}
+}
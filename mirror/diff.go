@@ -0,0 +1,179 @@
+package mirror
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// maxDiffLines bounds the size of the pages compared line by line; larger
+// pages only get a summary
+const maxDiffLines = 3000
+
+// diffReport collects the changes made to existing HTML pages during a
+// re-mirror
+type diffReport struct {
+	path string
+	mu   sync.Mutex
+	file *os.File
+}
+
+// previousText returns the visible text of the page about to be
+// overwritten, or nil if there is none or reporting is off
+func (m *Mirror) previousText(resource Resource) []string {
+	if m.diffs == nil || !resource.IsHTML {
+		return nil
+	}
+	content, err := os.ReadFile(resource.LocalPath)
+	if err != nil {
+		return nil
+	}
+	return pageText(content)
+}
+
+// reportChanges appends a diff of the page text to the report if the
+// page changed
+func (m *Mirror) reportChanges(resource Resource, before []string) {
+	if before == nil || !resource.IsHTML {
+		return
+	}
+	content, err := os.ReadFile(resource.LocalPath)
+	if err != nil {
+		return
+	}
+	after := pageText(content)
+	if equalLines(before, after) {
+		return
+	}
+	if err := m.diffs.write(resource.URL, before, after); err != nil {
+		fmt.Printf("Error writing diff report: %v\n", err)
+	}
+}
+
+func (r *diffReport) write(url string, before, after []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		r.file = f
+		fmt.Fprintf(f, "# changes found %s\n", time.Now().Format("2006-01-02 15:04:05"))
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "\n=== %s\n", url)
+	if len(before) > maxDiffLines || len(after) > maxDiffLines {
+		fmt.Fprintf(&b, "page text changed (%d -> %d lines, too large to diff)\n", len(before), len(after))
+	} else {
+		for _, line := range diffLines(before, after) {
+			b.WriteString(line)
+			b.WriteByte('\n')
+		}
+	}
+	_, err := r.file.WriteString(b.String())
+	return err
+}
+
+func (r *diffReport) close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file != nil {
+		r.file.Close()
+		r.file = nil
+	}
+}
+
+// pageText extracts the visible text of an HTML page, one non-empty line
+// per text node, skipping scripts and styles
+func pageText(content []byte) []string {
+	var lines []string
+	z := html.NewTokenizer(bytes.NewReader(content))
+	skip := 0
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return lines
+		case html.StartTagToken:
+			if name, _ := z.TagName(); string(name) == "script" || string(name) == "style" {
+				skip++
+			}
+		case html.EndTagToken:
+			if name, _ := z.TagName(); (string(name) == "script" || string(name) == "style") && skip > 0 {
+				skip--
+			}
+		case html.TextToken:
+			if skip > 0 {
+				continue
+			}
+			for _, line := range strings.Split(string(z.Text()), "\n") {
+				if line = strings.TrimSpace(line); line != "" {
+					lines = append(lines, line)
+				}
+			}
+		}
+	}
+}
+
+func equalLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// diffLines returns the removed ("- ") and added ("+ ") lines between a
+// and b, based on their longest common subsequence
+func diffLines(a, b []string) []string {
+	// lcs[i][j] is the LCS length of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var out []string
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			out = append(out, "- "+a[i])
+			i++
+		default:
+			out = append(out, "+ "+b[j])
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		out = append(out, "- "+a[i])
+	}
+	for ; j < len(b); j++ {
+		out = append(out, "+ "+b[j])
+	}
+	return out
+}
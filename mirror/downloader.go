@@ -1,32 +1,56 @@
 package mirror
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
 )
 
 // Downloader handles the downloading of resources
 type Downloader struct {
-	config *Config
-	client *http.Client
+	config       *Config
+	client       *http.Client
+	bytesWritten int64
+	warc         *warcWriter // nil unless WARCFile is set
+
+	// Called when a request was redirected to final, before the body is
+	// saved. It may move resource's LocalPath, and returns an error if
+	// the body is not to be saved by this download.
+	redirected func(resource *Resource, final *url.URL) error
 }
 
 // NewDownloader creates a new Downloader instance
 func NewDownloader(config *Config) *Downloader {
+	client := config.Client
+	if client == nil {
+		client = &http.Client{}
+	}
 	return &Downloader{
 		config: config,
-		client: &http.Client{},
+		client: client,
 	}
 }
 
-// Download downloads resources from the queue
+// BytesWritten returns the number of bytes saved to disk so far
+func (d *Downloader) BytesWritten() int64 {
+	return atomic.LoadInt64(&d.bytesWritten)
+}
+
+// Download downloads the resources pushed to the queue, returning once
+// it is drained
 func (d *Downloader) Download(queue *Queue, workers int) error {
 	var wg sync.WaitGroup
 	errors := make(chan error, workers)
+	queue.closeWhenDrained()
 
 	// Start worker goroutines
 	for i := 0; i < workers; i++ {
@@ -34,7 +58,9 @@ func (d *Downloader) Download(queue *Queue, workers int) error {
 		go func() {
 			defer wg.Done()
 			for resource := range queue.Resources {
-				if err := d.downloadResource(resource); err != nil {
+				err := d.downloadResource(context.Background(), &resource)
+				queue.finish(resource)
+				if err != nil {
 					errors <- fmt.Errorf("error downloading %s: %v", resource.URL, err)
 					return
 				}
@@ -56,23 +82,71 @@ func (d *Downloader) Download(queue *Queue, workers int) error {
 	return nil
 }
 
-// downloadResource downloads a single resource
-func (d *Downloader) downloadResource(resource Resource) error {
-	// Create directory if it doesn't exist
-	dir := filepath.Dir(resource.LocalPath)
-	if err := os.MkdirAll(dir, 0755); err != nil {
+// downloadResource downloads a single resource, recording its
+// Content-Type on the resource
+func (d *Downloader) downloadResource(ctx context.Context, resource *Resource) error {
+	req, err := d.config.newRequest(ctx, resource.URL)
+	if err != nil {
 		return err
 	}
 
+	// With timestamping, only fetch what changed since the local copy
+	var existing string
+	var existingInfo os.FileInfo
+	if d.config.Timestamping {
+		existing, existingInfo = d.existingCopy(resource)
+		if existingInfo != nil {
+			req.Header.Set("If-Modified-Since", existingInfo.ModTime().UTC().Format(http.TimeFormat))
+		}
+	}
+
 	// Download the file
-	resp, err := d.client.Get(resource.URL)
+	resp, err := d.client.Do(req)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotModified && existingInfo != nil {
+		d.archive(resp, bytes.NewReader(nil), 0)
+		resource.LocalPath = existing
+		resource.Size = existingInfo.Size()
+		resource.NotModified = true
+		return nil
+	}
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("received status code %d", resp.StatusCode)
+		if d.warc != nil {
+			body, _ := io.ReadAll(io.LimitReader(resp.Body, maxArchivedErrorBody))
+			d.archive(resp, bytes.NewReader(body), int64(len(body)))
+		}
+		return &statusError{
+			code:       resp.StatusCode,
+			retryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		}
+	}
+
+	// A body reached through a redirect is saved once, under where it
+	// came from
+	if final := normalizeURL(resp.Request.URL, d.config.SortQuery); final.String() != resource.URL && d.redirected != nil {
+		if err := d.redirected(resource, final); err != nil {
+			return err
+		}
+	}
+
+	// Skip what says up front it is too large
+	limit := d.config.MaxFileSize
+	if limit > 0 && resp.ContentLength > limit {
+		return &tooLargeError{size: resp.ContentLength, limit: limit}
+	}
+
+	resource.ContentType = resp.Header.Get("Content-Type")
+	if d.config.AdjustExtension {
+		resource.LocalPath = adjustExtension(resource.LocalPath, resource.ContentType)
+	}
+
+	// Create directory if it doesn't exist
+	if err := os.MkdirAll(filepath.Dir(resource.LocalPath), 0755); err != nil {
+		return err
 	}
 
 	// Create the file
@@ -82,11 +156,123 @@ func (d *Downloader) downloadResource(resource Resource) error {
 	}
 	defer f.Close()
 
-	// Copy the content
-	_, err = io.Copy(f, resp.Body)
+	digest := sha256.New()
+	w := io.MultiWriter(f, digest)
+	// Copy the content, hashing it for Dedupe and the manifest. Without
+	// a Content-Length the size limit is enforced as the body arrives.
+	var body io.Reader = resp.Body
+	if d.config.WrapBody != nil {
+		body = d.config.WrapBody(body)
+	}
+	if limit > 0 {
+		body = io.LimitReader(body, limit+1)
+	}
+	n, err := io.Copy(w, body)
+	atomic.AddInt64(&d.bytesWritten, n)
+	resource.Size = n
 	if err != nil {
 		return err
 	}
+	if limit > 0 && n > limit {
+		return &tooLargeError{size: -1, limit: limit}
+	}
+	resource.SHA256 = hex.EncodeToString(digest.Sum(nil))
+	if d.config.SaveHeaders != "" && !d.config.ListURLs && !d.config.Spider {
+		resource.Headers = formatHeaders(resp)
+		if d.config.SaveHeaders == "sidecar" {
+			if err := os.WriteFile(resource.LocalPath+".headers", []byte(resource.Headers), 0644); err != nil {
+				return err
+			}
+			resource.Headers = ""
+		}
+	}
+	if d.warc != nil {
+		if saved, err := os.Open(resource.LocalPath); err == nil {
+			d.archive(resp, saved, n)
+			saved.Close()
+		}
+	}
+
+	// Date the file as the server does, for the next If-Modified-Since
+	if !d.config.NoServerTimestamps {
+		if modified, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+			f.Close()
+			return os.Chtimes(resource.LocalPath, modified, modified)
+		}
+	}
 
 	return nil
 }
+
+// maxArchivedErrorBody caps how much of an error response's body goes
+// into the WARC file
+const maxArchivedErrorBody = 1 << 20
+
+// archive writes resp, whose body is size bytes read from body, to the
+// WARC file if there is one. A failure to write it is reported but does
+// not fail the download.
+func (d *Downloader) archive(resp *http.Response, body io.ReadSeeker, size int64) {
+	if d.warc == nil {
+		return
+	}
+	if err := d.warc.writeExchange(resp, body, size); err != nil {
+		fmt.Printf("Error writing WARC record for %s: %v\n", resp.Request.URL, err)
+	}
+}
+
+// newRequest returns a GET request for rawURL carrying Header and
+// UserAgent
+func (c *Config) newRequest(ctx context.Context, rawURL string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range c.Header {
+		for _, v := range values {
+			req.Header.Add(name, v)
+		}
+	}
+	if c.UserAgent != "" {
+		req.Header.Set("User-Agent", c.UserAgent)
+	}
+	return req, nil
+}
+
+// get fetches rawURL outside the queue, as for robots.txt and sitemaps
+func (d *Downloader) get(rawURL string) (*http.Response, error) {
+	req, err := d.config.newRequest(context.Background(), rawURL)
+	if err != nil {
+		return nil, err
+	}
+	return d.client.Do(req)
+}
+
+// tooLargeError reports a resource skipped for being over MaxFileSize
+type tooLargeError struct {
+	size  int64 // -1 if the body ran over the limit with no Content-Length
+	limit int64
+}
+
+func (e *tooLargeError) Error() string {
+	if e.size < 0 {
+		return fmt.Sprintf("larger than the %d byte limit", e.limit)
+	}
+	return fmt.Sprintf("size %d exceeds the %d byte limit", e.size, e.limit)
+}
+
+// existingCopy finds the copy of resource saved by an earlier run, which
+// AdjustExtension may have saved under another name
+func (d *Downloader) existingCopy(resource *Resource) (string, os.FileInfo) {
+	candidates := []string{resource.LocalPath}
+	if d.config.AdjustExtension {
+		candidates = append(candidates,
+			adjustExtension(resource.LocalPath, "text/html"),
+			adjustExtension(resource.LocalPath, "text/css"))
+	}
+	for _, p := range candidates {
+		if info, err := os.Stat(p); err == nil && info.Mode().IsRegular() {
+			return p, info
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,117 @@
+package mirror
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxNameLength is the longest file name saved. It leaves room under the
+// usual 255 byte limit for an extension added by AdjustExtension.
+const maxNameLength = 240
+
+// FileNameRules says which characters are escaped, as %XX, in the names
+// of saved files. The zero value escapes only what Unix does not allow:
+// '/' and control characters.
+type FileNameRules struct {
+	Windows   bool // Also escape \ | : ? " * < >, and save queries after '@' instead of '?'
+	NoControl bool // Don't escape control characters
+	ASCII     bool // Also escape bytes outside ASCII
+	Lowercase bool // Lowercase names
+	Uppercase bool // Uppercase names
+}
+
+// ParseFileNameRules parses a --restrict-file-names value, a comma
+// separated list of unix, windows, nocontrol, ascii, lowercase and
+// uppercase
+func ParseFileNameRules(modes string) (FileNameRules, error) {
+	var rules FileNameRules
+	for _, mode := range strings.Split(modes, ",") {
+		switch strings.ToLower(strings.TrimSpace(mode)) {
+		case "", "unix":
+		case "windows":
+			rules.Windows = true
+		case "nocontrol":
+			rules.NoControl = true
+		case "ascii":
+			rules.ASCII = true
+		case "lowercase":
+			rules.Lowercase = true
+		case "uppercase":
+			rules.Uppercase = true
+		default:
+			return rules, fmt.Errorf("unknown file name restriction %q", mode)
+		}
+	}
+	if rules.Lowercase && rules.Uppercase {
+		return rules, fmt.Errorf("lowercase and uppercase file names are mutually exclusive")
+	}
+	return rules, nil
+}
+
+// querySeparator returns what separates a saved file name from its query
+func (r FileNameRules) querySeparator() string {
+	if r.Windows {
+		return "@"
+	}
+	return "?"
+}
+
+// escaped reports whether byte c must be escaped in a file name
+func (r FileNameRules) escaped(c byte) bool {
+	switch {
+	case c == '/' || c == 0:
+		return true
+	case c < 32 || c == 127:
+		return !r.NoControl
+	case c >= 128:
+		return r.ASCII
+	}
+	return r.Windows && strings.IndexByte(`\|:?"*<>`, c) >= 0
+}
+
+// name returns segment made safe to use as a single file or directory
+// name
+func (r FileNameRules) name(segment string) string {
+	var b strings.Builder
+	for i := 0; i < len(segment); i++ {
+		if c := segment[i]; r.escaped(c) {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	name := b.String()
+
+	switch {
+	case r.Lowercase:
+		name = strings.ToLower(name)
+	case r.Uppercase:
+		name = strings.ToUpper(name)
+	}
+
+	// Overlong names are cut short and marked with a hash of the whole
+	// name, so names sharing a long prefix, such as those of a page with
+	// different long queries, still differ
+	if len(name) > maxNameLength {
+		sum := sha256.Sum256([]byte(name))
+		suffix := "~" + hex.EncodeToString(sum[:4])
+		cut := maxNameLength - len(suffix)
+		for cut > 0 && !utf8.RuneStart(name[cut]) {
+			cut--
+		}
+		name = name[:cut] + suffix
+	}
+	return name
+}
+
+// host returns the directory name for host. Windows does not allow the
+// ':' before a port, so '+' is used there instead.
+func (r FileNameRules) host(host string) string {
+	if r.Windows {
+		host = strings.ReplaceAll(host, ":", "+")
+	}
+	return r.name(host)
+}
@@ -0,0 +1,246 @@
+package mirror
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// spillAt is how many resources the backlog holds in memory before it
+// moves them to disk. It is a variable so tests can spill sooner.
+var spillAt = 10000
+
+// backlog is a first-in, first-out queue of resources with no limit on
+// its length. Past spillAt resources it moves what it holds to a
+// temporary file, one JSON object per line, so a crawl's breadth costs
+// disk rather than memory. The resources in the file all come before
+// those in tail. It isn't safe for concurrent use.
+type backlog struct {
+	tail    []Resource
+	file    *os.File // Nil until the first spill
+	reader  *bufio.Reader
+	readAt  int64 // Offset of the first spilled resource not yet popped
+	writeAt int64
+	spilled int  // Resources in the file not yet popped
+	failed  bool // Spilling failed, so everything stays in memory
+}
+
+func (b *backlog) len() int {
+	return b.spilled + len(b.tail)
+}
+
+// push adds r to the end of the backlog
+func (b *backlog) push(r Resource) {
+	b.tail = append(b.tail, r)
+	if len(b.tail) >= spillAt && !b.failed {
+		if err := b.spill(); err != nil {
+			fmt.Printf("Error spilling the frontier to disk, keeping it in memory: %v\n", err)
+			b.failed = true
+		}
+	}
+}
+
+// spill appends tail to the file
+func (b *backlog) spill() error {
+	if b.file == nil {
+		f, err := os.CreateTemp("", "wget-frontier-*.jsonl")
+		if err != nil {
+			return err
+		}
+		b.file = f
+		b.reader = bufio.NewReader(io.NewSectionReader(f, 0, 1<<62))
+	}
+	var data []byte
+	for _, r := range b.tail {
+		line, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		data = append(append(data, line...), '\n')
+	}
+	if _, err := b.file.WriteAt(data, b.writeAt); err != nil {
+		return err
+	}
+	b.writeAt += int64(len(data))
+	b.spilled += len(b.tail)
+	b.tail = nil
+	return nil
+}
+
+// pop takes the first resource off the backlog, which must not be empty.
+// If the file can't be read back, the resources in it are lost: ok is
+// false and lost is how many there were.
+func (b *backlog) pop() (r Resource, lost int, ok bool) {
+	if b.spilled == 0 {
+		r = b.tail[0]
+		b.tail[0] = Resource{}
+		b.tail = b.tail[1:]
+		if len(b.tail) == 0 {
+			b.tail = nil
+		}
+		return r, 0, true
+	}
+
+	if b.reader.Buffered() == 0 {
+		// Read on up to what is spilled by now. A reader that reached
+		// the end of the file before more was spilled would go on
+		// reporting EOF.
+		b.reader.Reset(io.NewSectionReader(b.file, b.readAt, b.writeAt-b.readAt))
+	}
+	line, err := b.reader.ReadBytes('\n')
+	if err == nil {
+		err = json.Unmarshal(line, &r)
+	}
+	if err != nil {
+		fmt.Printf("Error reading the frontier back from disk, dropping %d resources: %v\n", b.spilled, err)
+		lost = b.spilled
+		b.spilled = 0
+		b.rewind()
+		return r, lost, false
+	}
+	b.readAt += int64(len(line))
+	if b.spilled--; b.spilled == 0 {
+		b.rewind()
+	}
+	return r, 0, true
+}
+
+// rewind empties the file once everything in it is popped, so it is
+// reused from the start
+func (b *backlog) rewind() {
+	b.file.Truncate(0)
+	b.readAt, b.writeAt = 0, 0
+	b.reader.Reset(io.NewSectionReader(b.file, 0, 1<<62))
+}
+
+// resources returns every resource in the backlog, in order
+func (b *backlog) resources() ([]Resource, error) {
+	resources := make([]Resource, 0, b.len())
+	if b.spilled > 0 {
+		scanner := bufio.NewScanner(io.NewSectionReader(b.file, b.readAt, b.writeAt-b.readAt))
+		scanner.Buffer(nil, 1<<24)
+		for scanner.Scan() {
+			var r Resource
+			if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+				return nil, err
+			}
+			resources = append(resources, r)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return append(resources, b.tail...), nil
+}
+
+// close removes the file
+func (b *backlog) close() {
+	if b.file != nil {
+		b.file.Close()
+		os.Remove(b.file.Name())
+		b.file = nil
+	}
+}
+
+// prioritized is the backlog of resources waiting to be fetched, kept as
+// one backlog for each rank. Resources of the lowest rank waiting are
+// taken first, and those of one rank in the order they were pushed.
+type prioritized struct {
+	rank     func(Resource) int // Nil to take every resource in the order pushed
+	backlogs map[int]*backlog
+}
+
+// crawlRank returns the rank function for a CrawlOrder
+func crawlRank(order string) func(Resource) int {
+	switch order {
+	case "html-first":
+		// Pages are parsed early, so links are discovered sooner. Every
+		// link followed is queued as a possible page, so what its URL
+		// looks like tells pages from the files they link to.
+		return func(r Resource) int {
+			var ext string
+			if u, err := url.Parse(r.URL); err == nil {
+				ext = strings.ToLower(strings.TrimPrefix(path.Ext(u.Path), "."))
+			}
+			if isPageExt(ext) {
+				return 0
+			}
+			return 1
+		}
+	case "depth":
+		return func(r Resource) int {
+			return r.Depth
+		}
+	}
+	return nil
+}
+
+func (p *prioritized) len() int {
+	n := 0
+	for _, b := range p.backlogs {
+		n += b.len()
+	}
+	return n
+}
+
+func (p *prioritized) push(r Resource) {
+	rank := 0
+	if p.rank != nil {
+		rank = p.rank(r)
+	}
+	if p.backlogs == nil {
+		p.backlogs = map[int]*backlog{}
+	}
+	b := p.backlogs[rank]
+	if b == nil {
+		b = &backlog{}
+		p.backlogs[rank] = b
+	}
+	b.push(r)
+}
+
+// pop takes a resource of the lowest rank waiting, as backlog.pop does
+func (p *prioritized) pop() (r Resource, lost int, ok bool) {
+	for _, rank := range p.ranks() {
+		if b := p.backlogs[rank]; b.len() > 0 {
+			return b.pop()
+		}
+	}
+	return r, 0, false
+}
+
+// ranks returns the ranks of the backlogs, lowest first
+func (p *prioritized) ranks() []int {
+	ranks := make([]int, 0, len(p.backlogs))
+	for rank := range p.backlogs {
+		ranks = append(ranks, rank)
+	}
+	sort.Ints(ranks)
+	return ranks
+}
+
+// resources returns every resource waiting, in the order they would be
+// taken
+func (p *prioritized) resources() ([]Resource, error) {
+	var resources []Resource
+	for _, rank := range p.ranks() {
+		rs, err := p.backlogs[rank].resources()
+		if err != nil {
+			return resources, err
+		}
+		resources = append(resources, rs...)
+	}
+	return resources, nil
+}
+
+func (p *prioritized) close() {
+	for _, b := range p.backlogs {
+		b.close()
+	}
+}
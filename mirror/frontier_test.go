@@ -0,0 +1,136 @@
+package mirror
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestBacklogOrder(t *testing.T) {
+	defer func(n int) { spillAt = n }(spillAt)
+	spillAt = 4
+
+	var b backlog
+	defer b.close()
+	next, popped := 0, 0
+	push := func(n int) {
+		for i := 0; i < n; i++ {
+			b.push(Resource{URL: fmt.Sprint(next)})
+			next++
+		}
+	}
+	pop := func(n int) {
+		for i := 0; i < n; i++ {
+			r, lost, ok := b.pop()
+			if !ok || lost != 0 {
+				t.Fatalf("pop = %v, %d lost, ok %v", r, lost, ok)
+			}
+			if want := fmt.Sprint(popped); r.URL != want {
+				t.Fatalf("pop = %s, want %s", r.URL, want)
+			}
+			popped++
+		}
+	}
+
+	push(10)
+	if b.file == nil || b.spilled != 8 || len(b.tail) != 2 {
+		t.Fatalf("after 10 pushes, %d spilled and %d in memory, want 8 and 2", b.spilled, len(b.tail))
+	}
+	pop(3)
+	push(3)
+
+	rs, err := b.resources()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rs) != b.len() || len(rs) != next-popped {
+		t.Fatalf("resources returns %d, want %d", len(rs), next-popped)
+	}
+	for i, r := range rs {
+		if want := fmt.Sprint(popped + i); r.URL != want {
+			t.Fatalf("resources()[%d] = %s, want %s", i, r.URL, want)
+		}
+	}
+
+	// Popping everything spilled rewinds the file for reuse
+	pop(b.spilled)
+	if b.readAt != 0 || b.writeAt != 0 {
+		t.Errorf("file not rewound once read: read at %d, write at %d", b.readAt, b.writeAt)
+	}
+	push(5)
+	pop(b.len())
+	if b.len() != 0 || popped != next {
+		t.Errorf("%d left after popping %d of %d", b.len(), popped, next)
+	}
+}
+
+func TestPrioritized(t *testing.T) {
+	p := prioritized{rank: crawlRank("depth")}
+	defer p.close()
+	for i, depth := range []int{2, 0, 1, 0, 2, 1} {
+		p.push(Resource{URL: fmt.Sprint(i), Depth: depth})
+	}
+
+	want := []string{"1", "3", "2", "5", "0", "4"}
+	rs, err := p.resources()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := urlsOf(rs); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("resources = %v, want %v", got, want)
+	}
+
+	var got []string
+	for p.len() > 0 {
+		r, _, ok := p.pop()
+		if !ok {
+			t.Fatal("pop failed")
+		}
+		got = append(got, r.URL)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("popped %v, want %v", got, want)
+	}
+	if _, _, ok := p.pop(); ok {
+		t.Error("pop of an empty backlog succeeds")
+	}
+}
+
+func TestPrioritizedFIFO(t *testing.T) {
+	p := prioritized{rank: crawlRank("fifo")}
+	defer p.close()
+	for i, depth := range []int{2, 0, 1} {
+		p.push(Resource{URL: fmt.Sprint(i), Depth: depth, IsHTML: i == 2})
+	}
+	rs, err := p.resources()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := urlsOf(rs); fmt.Sprint(got) != "[0 1 2]" {
+		t.Errorf("resources = %v, want the order pushed", got)
+	}
+}
+
+func urlsOf(rs []Resource) []string {
+	urls := make([]string, len(rs))
+	for i, r := range rs {
+		urls[i] = r.URL
+	}
+	return urls
+}
+
+func TestPrioritizedHTMLFirst(t *testing.T) {
+	p := prioritized{rank: crawlRank("html-first")}
+	defer p.close()
+	for _, u := range []string{"/a.pdf", "/b.html", "/c.png", "/d/", "/e.php?x=1", "/f.zip"} {
+		// Links followed are all queued as possible pages
+		p.push(Resource{URL: "http://example.com" + u, IsHTML: true})
+	}
+	rs, err := p.resources()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "[http://example.com/b.html http://example.com/d/ http://example.com/e.php?x=1 http://example.com/a.pdf http://example.com/c.png http://example.com/f.zip]"
+	if got := urlsOf(rs); fmt.Sprint(got) != want {
+		t.Errorf("resources = %v, want pages first", got)
+	}
+}
@@ -0,0 +1,41 @@
+package mirror
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// formatHeaders renders the status line and headers of resp the way
+// they came over the wire, ending in the blank line
+func formatHeaders(resp *http.Response) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s\r\n", resp.Proto, resp.Status)
+	resp.Header.Write(&b)
+	b.WriteString("\r\n")
+	return b.String()
+}
+
+// prependHeaders puts the response head kept on resource before its
+// saved body, as wget's --save-headers does, keeping the file's
+// modification time for the next If-Modified-Since
+func (m *Mirror) prependHeaders(resource Resource) {
+	if resource.Headers == "" {
+		return
+	}
+	info, err := os.Stat(resource.LocalPath)
+	if err != nil {
+		return
+	}
+	content, err := os.ReadFile(resource.LocalPath)
+	if err == nil {
+		err = os.WriteFile(resource.LocalPath, append([]byte(resource.Headers), content...), info.Mode().Perm())
+	}
+	if err == nil {
+		err = os.Chtimes(resource.LocalPath, info.ModTime(), info.ModTime())
+	}
+	if err != nil {
+		fmt.Printf("Error saving headers to %s: %v\n", resource.LocalPath, err)
+	}
+}
@@ -0,0 +1,62 @@
+package mirror
+
+import (
+	"net/url"
+	"sync"
+	"time"
+)
+
+// busyHostDelay is how long a resource whose origin has no free slot is
+// put off before it is tried again
+const busyHostDelay = 100 * time.Millisecond
+
+// hostSlots counts the downloads in flight from each origin, so workers
+// can pass over origins at their PerHost limit instead of waiting on them
+type hostSlots struct {
+	limit int
+	mu    sync.Mutex
+	busy  map[string]int
+}
+
+func newHostSlots(limit int) *hostSlots {
+	return &hostSlots{limit: limit, busy: map[string]int{}}
+}
+
+// origin returns the scheme and host of rawURL, which its downloads are
+// counted under
+func origin(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// tryAcquire takes a slot for the origin of resource and returns true,
+// or returns false if the origin has none free
+func (h *hostSlots) tryAcquire(resource Resource) bool {
+	if h.limit <= 0 {
+		return true
+	}
+	key := origin(resource.URL)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.busy[key] >= h.limit {
+		return false
+	}
+	h.busy[key]++
+	return true
+}
+
+// release gives back a slot taken by tryAcquire
+func (h *hostSlots) release(resource Resource) {
+	if h.limit <= 0 {
+		return
+	}
+	key := origin(resource.URL)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.busy[key]--; h.busy[key] <= 0 {
+		delete(h.busy, key)
+	}
+}
@@ -0,0 +1,77 @@
+package mirror
+
+import (
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// localPath returns where the resource at u is saved:
+// OutputDir/host/dir/file?query by default, without the host directory when
+// NoHostDirectories is set, without the first CutDirs directories, and
+// straight in OutputDir when NoDirectories is set. URLs of directories
+// are saved as their index.html. Every name is restricted by FileNames.
+func localPath(config *Config, u *url.URL) string {
+	rules := config.FileNames
+	dir, file := path.Split(u.Path)
+	if file == "" {
+		file = "index.html"
+	}
+	// Keep the query in the name, as in "page?id=1", so URLs that
+	// differ only in their query don't overwrite each other
+	if u.RawQuery != "" {
+		file += rules.querySeparator() + u.RawQuery
+	}
+	file = rules.name(file)
+	if config.NoDirectories {
+		return path.Join(config.OutputDir, file)
+	}
+
+	var dirs []string
+	for _, d := range strings.Split(dir, "/") {
+		if d != "" {
+			dirs = append(dirs, rules.name(d))
+		}
+	}
+	if config.CutDirs >= len(dirs) {
+		dirs = nil
+	} else if config.CutDirs > 0 {
+		dirs = dirs[config.CutDirs:]
+	}
+
+	parts := []string{config.OutputDir}
+	if !config.NoHostDirectories {
+		parts = append(parts, rules.host(u.Host))
+	}
+	parts = append(parts, dirs...)
+	return path.Join(append(parts, file)...)
+}
+
+// adjustExtension returns p with ".html" or ".css" appended when
+// contentType is HTML or CSS and p lacks the matching extension
+func adjustExtension(p, contentType string) string {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	ext := strings.ToLower(path.Ext(p))
+	switch strings.ToLower(strings.TrimSpace(mediaType)) {
+	case "text/html", "application/xhtml+xml":
+		if ext != ".html" && ext != ".htm" {
+			return p + ".html"
+		}
+	case "text/css":
+		if ext != ".css" {
+			return p + ".css"
+		}
+	}
+	return p
+}
+
+// relativeLink returns a link to the file at to from a page saved in
+// dir. Characters such as the '?' of a saved query are escaped.
+func relativeLink(dir, to string) string {
+	rel, err := filepath.Rel(dir, to)
+	if err != nil {
+		rel = to
+	}
+	return (&url.URL{Path: filepath.ToSlash(rel)}).EscapedPath()
+}
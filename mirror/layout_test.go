@@ -0,0 +1,55 @@
+package mirror
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestLocalPath(t *testing.T) {
+	tests := []struct {
+		config Config
+		url    string
+		want   string
+	}{
+		{Config{OutputDir: "out"}, "http://example.com/a/b.html", "out/example.com/a/b.html"},
+		{Config{OutputDir: "out", NoHostDirectories: true}, "http://example.com/a/b", "out/a/b"},
+		{Config{OutputDir: "out", CutDirs: 1}, "http://example.com/a/b/c", "out/example.com/b/c"},
+		{Config{OutputDir: "out", CutDirs: 5}, "http://example.com/a/b/c", "out/example.com/c"},
+		{Config{OutputDir: "out", NoDirectories: true}, "http://example.com/a/b/c", "out/c"},
+		{Config{OutputDir: "out"}, "http://example.com/a/", "out/example.com/a/index.html"},
+		{Config{OutputDir: "out"}, "http://example.com/", "out/example.com/index.html"},
+		{Config{OutputDir: "out", NoDirectories: true}, "http://example.com/a/", "out/index.html"},
+		{Config{OutputDir: "out"}, "http://example.com/page?id=1", "out/example.com/page?id=1"},
+		{Config{OutputDir: "out"}, "http://example.com/a/?q=x/y", "out/example.com/a/index.html?q=x%2Fy"},
+		{Config{OutputDir: "out", FileNames: FileNameRules{Windows: true}}, "http://example.com:8080/a:b?q=1", "out/example.com+8080/a%3Ab@q=1"},
+		{Config{OutputDir: "out", FileNames: FileNameRules{Lowercase: true}}, "http://example.com/A/B", "out/example.com/a/b"},
+		{Config{OutputDir: "out", FileNames: FileNameRules{ASCII: true}}, "http://example.com/caf%C3%A9", "out/example.com/caf%C3%A9"},
+		{Config{OutputDir: "out"}, "http://example.com/tab%09name", "out/example.com/tab%09name"},
+	}
+	for _, tt := range tests {
+		u, err := url.Parse(tt.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := localPath(&tt.config, u); got != tt.want {
+			t.Errorf("localPath(%s) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestLocalPathLongName(t *testing.T) {
+	config := Config{OutputDir: "out"}
+	long := strings.Repeat("a", 300)
+	a, _ := url.Parse("http://example.com/" + long + "?x=1")
+	b, _ := url.Parse("http://example.com/" + long + "?x=2")
+	pa, pb := localPath(&config, a), localPath(&config, b)
+	if pa == pb {
+		t.Errorf("names cut short collide: %q", pa)
+	}
+	for _, p := range []string{pa, pb} {
+		if name := p[strings.LastIndex(p, "/")+1:]; len(name) > maxNameLength {
+			t.Errorf("name of %d bytes, over %d", len(name), maxNameLength)
+		}
+	}
+}
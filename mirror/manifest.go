@@ -0,0 +1,174 @@
+package mirror
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+)
+
+// manifestEntry describes one resource of the mirror in the manifest
+type manifestEntry struct {
+	URL         string `json:"url"`
+	LocalPath   string `json:"local_path,omitempty"` // "" if nothing is kept, e.g. after an error
+	Status      string `json:"status"`
+	Size        int64  `json:"size"`
+	SHA256      string `json:"sha256,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Depth       int    `json:"depth"`
+	Referrer    string `json:"referrer,omitempty"`
+}
+
+// manifest collects an entry for every resource processed
+type manifest struct {
+	mu      sync.Mutex
+	entries map[string]manifestEntry
+}
+
+// record adds resource, as it stands once processed, to the manifest
+func (m *Mirror) record(resource Resource) {
+	status := m.status.get(resource.URL)
+	if status == "" {
+		status = "ok"
+		if resource.NotModified {
+			status = "not modified"
+		}
+	}
+
+	entry := manifestEntry{
+		URL:         resource.URL,
+		Status:      status,
+		ContentType: resource.ContentType,
+		Depth:       resource.Depth,
+		Referrer:    resource.Referrer,
+	}
+
+	// Only what is still on disk has a path, size and hash. Unless links
+	// are to be converted, nothing needs where it was saved after this.
+	m.queue.ProcessLock.Lock()
+	saved, ok := m.queue.Saved[resource.URL]
+	if ok && !m.config.ConvertLinks {
+		delete(m.queue.Saved, resource.URL)
+		delete(m.queue.Saved, resource.RedirectedTo)
+	}
+	m.queue.ProcessLock.Unlock()
+	if ok {
+		entry.LocalPath = saved
+		entry.Size = resource.Size
+		entry.SHA256 = resource.SHA256
+		if resource.NotModified || entry.SHA256 == "" {
+			entry.Size, entry.SHA256 = hashFile(saved)
+		}
+	}
+
+	m.manifest.mu.Lock()
+	defer m.manifest.mu.Unlock()
+	if m.manifest.entries == nil {
+		m.manifest.entries = map[string]manifestEntry{}
+	}
+	m.manifest.entries[resource.URL] = entry
+}
+
+// hashFile returns the size and hex SHA-256 of the file at path, or
+// zeros if it can't be read
+func hashFile(path string) (int64, string) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, ""
+	}
+	defer f.Close()
+	digest := sha256.New()
+	n, err := io.Copy(digest, f)
+	if err != nil {
+		return 0, ""
+	}
+	return n, hex.EncodeToString(digest.Sum(nil))
+}
+
+// readManifest reads the manifest written to path by an earlier run
+func readManifest(path string) ([]manifestEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entries []manifestEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return entries, nil
+}
+
+// writeManifest writes the manifest to ManifestFile as a JSON array
+// sorted by URL, and reports how the mirror changed since the manifest
+// it replaces. A resumed crawl keeps the entries of the run it picks up
+// from, so the manifest covers the whole mirror.
+func (m *Mirror) writeManifest() error {
+	previous, err := readManifest(m.config.ManifestFile)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		fmt.Printf("Ignoring the previous manifest: %v\n", err)
+	}
+
+	m.manifest.mu.Lock()
+	entries := make([]manifestEntry, 0, len(m.manifest.entries))
+	for _, entry := range m.manifest.entries {
+		entries = append(entries, entry)
+	}
+	m.manifest.mu.Unlock()
+
+	if m.config.Resume {
+		seen := map[string]bool{}
+		for _, entry := range entries {
+			seen[entry.URL] = true
+		}
+		for _, entry := range previous {
+			if !seen[entry.URL] {
+				entries = append(entries, entry)
+			}
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].URL < entries[j].URL
+	})
+
+	if previous != nil {
+		added, changed, gone := compareManifests(previous, entries)
+		fmt.Printf("Since the last manifest: %d new, %d changed, %d gone\n", added, changed, gone)
+	}
+
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(m.config.ManifestFile, append(data, '\n'), 0644)
+}
+
+// compareManifests counts the saved resources that are new in after,
+// whose content differs between before and after, and that after no
+// longer has
+func compareManifests(before, after []manifestEntry) (added, changed, gone int) {
+	old := map[string]string{}
+	for _, entry := range before {
+		if entry.LocalPath != "" {
+			old[entry.URL] = entry.SHA256
+		}
+	}
+	for _, entry := range after {
+		if entry.LocalPath == "" {
+			continue
+		}
+		sum, ok := old[entry.URL]
+		switch {
+		case !ok:
+			added++
+		case sum != entry.SHA256:
+			changed++
+		}
+		delete(old, entry.URL)
+	}
+	return added, changed, len(old)
+}
@@ -1,12 +1,19 @@
 package mirror
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"net/http"
 	"net/url"
 	"os"
 	"path"
+	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // Mirror handles the website mirroring process
@@ -16,6 +23,33 @@ type Mirror struct {
 	downloader *Downloader
 	converter  *Converter
 	queue      *Queue
+
+	failures     []failure
+	failuresLock sync.Mutex
+
+	slow     []slowResponse // With SlowThreshold, the responses slower than it
+	slowLock sync.Mutex
+
+	diffs *diffReport // nil unless DiffReport is set
+
+	hosts *hostScheduler
+	slots *hostSlots
+
+	status resourceStatus
+
+	// Pages and stylesheets whose links are converted once the crawl
+	// is done, when where everything was saved is known
+	toConvert     []Resource
+	toConvertLock sync.Mutex
+
+	counts runCounts
+
+	started atomic.Int64 // Resources taken off the queue to process
+	shelved atomic.Int64 // Resources left for a later run by MaxResources
+
+	contents contentIndex // with Dedupe, what has been saved so far
+
+	manifest manifest // with ManifestFile, every resource processed
 }
 
 // New creates a new Mirror instance
@@ -26,13 +60,21 @@ func New(config *Config) (*Mirror, error) {
 		return nil, err
 	}
 
+	// Key the seed the way every discovered URL is keyed
+	baseURL = normalizeURL(baseURL, config.SortQuery)
+	config.URL = baseURL.String()
+
 	// Create output directory if it doesn't exist
 	if config.OutputDir == "" {
 		config.OutputDir = baseURL.Host
 	}
+	if config.StateFile == "" {
+		config.StateFile = defaultStateFile(config.OutputDir)
+	}
 	
 	// Create queue
 	queue := NewQueue()
+	queue.backlog.rank = crawlRank(config.CrawlOrder)
 
 	// Create components
 	parser, err := NewParser(config.URL, config, queue)
@@ -42,78 +84,629 @@ func New(config *Config) (*Mirror, error) {
 
 	downloader := NewDownloader(config)
 	
-	converter, err := NewConverter(config.URL, config)
+	converter, err := NewConverter(config.URL, config, queue)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Mirror{
+	m := &Mirror{
 		config:     config,
 		parser:     parser,
 		downloader: downloader,
 		converter:  converter,
 		queue:      queue,
-	}, nil
+	}
+	if config.DiffReport != "" {
+		m.diffs = &diffReport{path: config.DiffReport}
+	}
+	if parser.robots != nil {
+		m.hosts = newHostScheduler(config, parser.robots.crawlDelay)
+	} else {
+		m.hosts = newHostScheduler(config, nil)
+	}
+	m.slots = newHostSlots(config.PerHost)
+	if config.WARCFile != "" && !config.ListURLs && !config.Spider {
+		if downloader.warc, err = newWARCWriter(config); err != nil {
+			return nil, err
+		}
+	}
+	downloader.redirected = m.redirected
+	parser.full = m.overQuota
+	return m, nil
 }
 
 // Start begins the mirroring process
 func (m *Mirror) Start() error {
+	start := time.Now()
+
 	// Create initial resource
 	initialResource := Resource{
-		URL:       m.config.URL,
-		LocalPath: path.Join(m.config.OutputDir, path.Base(m.config.URL)),
-		IsHTML:    true,
+		URL:        m.config.URL,
+		LocalPath:  m.parser.localPath(m.parser.baseURL),
+		IsHTML:     true,
+		Unaccepted: len(m.config.AcceptTypes) > 0 && !containsFold(m.config.AcceptTypes, strings.TrimPrefix(path.Ext(m.config.URL), ".")),
+	}
+
+	var state *crawlState
+	if m.config.Resume {
+		var err error
+		if state, err = m.loadState(); err != nil {
+			return err
+		}
+	}
+	if m.config.VisitedStore != "" {
+		// A resumed crawl picks up the store where its state left it
+		var keep int64
+		if state != nil {
+			keep = state.VisitedStored
+		}
+		store, err := openDiskVisited(m.config.VisitedStore, keep)
+		if err != nil {
+			return err
+		}
+		m.queue.Processed = store
 	}
 
 	// Add to queue
-	m.queue.Resources <- initialResource
-	m.queue.Processed[m.config.URL] = true
+	if state != nil {
+		// Concurrently with the downloads, as the frontier can hold
+		// more than the queue does
+		m.queue.hold()
+		go func() {
+			defer m.queue.Done()
+			m.resume(state)
+		}()
+	} else if len(m.config.PageList) > 0 {
+		m.queuePages()
+	} else {
+		m.queue.Processed.Add(m.config.URL)
+		m.queue.Push(initialResource)
+		if m.config.Sitemaps {
+			// Concurrently with the downloads, as a sitemap can list
+			// more pages than the queue holds. It counts as pending
+			// work so the queue stays open until it is done.
+			m.queue.hold()
+			go func() {
+				defer m.queue.Done()
+				m.seedFromSitemaps()
+			}()
+		}
+	}
 
-	// Start download workers
-	var wg sync.WaitGroup
-	wg.Add(1)
-	
-	// Process queue
-	go func() {
-		defer wg.Done()
-		defer close(m.queue.Resources)
-
-		for resource := range m.queue.Resources {
-			// Download the resource
-			if err := m.downloader.downloadResource(resource); err != nil {
-				fmt.Printf("Error downloading %s: %v\n", resource.URL, err)
-				continue
-			}
+	// The queue closes once every queued resource has been processed,
+	// which stops the workers
+	m.queue.closeWhenDrained()
+
+	workers := m.config.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	// Save the crawl state as it goes, so an interrupted run can be
+	// resumed. A spider leaves nothing on disk.
+	stop := make(chan struct{})
+	if !m.config.Spider {
+		go m.saveStatePeriodically(stop)
+	}
 
-			// If it's HTML, parse it for more links
-			if resource.IsHTML {
-				f, err := os.Open(resource.LocalPath)
-				if err != nil {
-					fmt.Printf("Error opening %s: %v\n", resource.LocalPath, err)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for resource := range m.queue.Resources {
+				// Past the quota, what is still queued is dropped
+				// and only downloads in flight finish
+				if m.overQuota() {
+					m.parser.skip("quota")
+					m.queue.finish(resource)
 					continue
 				}
-
-				if err := m.parser.Parse(f); err != nil {
-					fmt.Printf("Error parsing %s: %v\n", resource.LocalPath, err)
+				// Once the visited store fails, the rest is
+				// dropped too and the run ends with its error
+				if m.queue.Processed.Err() != nil {
+					m.queue.finish(resource)
+					continue
 				}
-				f.Close()
-
-				// Convert links if needed
-				if m.config.ConvertLinks {
-					if err := m.converter.ConvertLinks(resource.LocalPath); err != nil {
-						fmt.Printf("Error converting links in %s: %v\n", resource.LocalPath, err)
-					}
+				// Put off resources whose origin is busy or whose
+				// host is not due yet and move on to the next one
+				if !m.slots.tryAcquire(resource) {
+					m.queue.pushLater(resource, busyHostDelay)
+					continue
+				}
+				if wait := m.due(resource); wait > 0 {
+					m.slots.release(resource)
+					m.queue.pushLater(resource, wait)
+					continue
+				}
+				// Past MaxResources, the rest of the frontier is
+				// left for a later run
+				if m.config.MaxResources > 0 && resource.Attempts == 0 && m.started.Add(1) > int64(m.config.MaxResources) {
+					m.slots.release(resource)
+					m.shelved.Add(1)
+					m.queue.shelve(resource)
+					continue
+				}
+				// A resource to be retried is back on the queue
+				// and still pending
+				requeued := m.process(resource)
+				m.slots.release(resource)
+				if !requeued {
+					m.queue.finish(resource)
 				}
 			}
-		}
-	}()
+		}()
+	}
 
-	// Wait for completion
+	// Wait for completion. Unless MaxResources cut the crawl short
+	// and the rest is to be kept, there is nothing left to resume. A
+	// failed visited store leaves the last state saved before it failed.
 	wg.Wait()
+	close(stop)
+	m.convertLinks()
+	storeErr := m.queue.Processed.Err()
+	keepState := storeErr != nil || m.shelved.Load() > 0 && m.config.KeepFrontier
+	if keepState && storeErr == nil {
+		if err := m.saveState(); err != nil {
+			fmt.Printf("Error saving mirror state: %v\n", err)
+		}
+	} else if !keepState {
+		os.Remove(m.config.StateFile)
+	}
+	if err := m.queue.Processed.Close(); err != nil {
+		fmt.Printf("Error closing visited store: %v\n", err)
+	}
+	if m.config.VisitedStore != "" && !keepState {
+		removeVisitedStore(m.config.VisitedStore)
+	}
+	if m.diffs != nil {
+		m.diffs.close()
+	}
+	if m.downloader.warc != nil {
+		if err := m.downloader.warc.close(); err != nil {
+			fmt.Printf("Error closing WARC file: %v\n", err)
+		}
+	}
+	if m.config.BrokenLinks != "" {
+		if err := m.writeBrokenLinks(); err != nil {
+			fmt.Printf("Error writing broken link report: %v\n", err)
+		}
+	}
+	if m.config.ManifestFile != "" && !m.config.ListURLs && !m.config.Spider {
+		if err := m.writeManifest(); err != nil {
+			fmt.Printf("Error writing manifest: %v\n", err)
+		}
+	}
+	if err := m.printSummary(start); err != nil {
+		fmt.Printf("Error writing mirror summary: %v\n", err)
+	}
+	if storeErr != nil {
+		return fmt.Errorf("visited store %s: %v", m.config.VisitedStore, storeErr)
+	}
+	if m.config.Spider {
+		return m.spiderReport()
+	}
+
+	return m.strictError()
+}
+
+// parseCSS queues what the stylesheet resource references
+func (m *Mirror) parseCSS(resource Resource) {
+	f, err := os.Open(m.unconverted(resource))
+	if err != nil {
+		fmt.Printf("Error opening %s: %v\n", resource.LocalPath, err)
+		return
+	}
+	defer f.Close()
+	if err := m.parser.ParseCSS(f, resource.location(), resource.Depth); err != nil {
+		fmt.Printf("Error parsing %s: %v\n", resource.LocalPath, err)
+	}
+}
+
+// parseLinks queues what a downloaded page or stylesheet links to. It
+// reports whether, with HonorNofollow, the page asks not to be kept.
+func (m *Mirror) parseLinks(resource Resource) (noindex bool) {
+	// Stylesheets pull in fonts, images and other stylesheets, as
+	// inline styles do. PageRequisites only lifts the host and depth
+	// limits on them.
+	if isCSS(resource) {
+		m.parseCSS(resource)
+	}
+
+	// If it's HTML, parse it for more links, decoded from its charset
+	if resource.IsHTML {
+		content, err := os.ReadFile(m.unconverted(resource))
+		if err != nil {
+			fmt.Printf("Error opening %s: %v\n", resource.LocalPath, err)
+			return false
+		}
+		content, _, _ = decodeDocument(resource.ContentType, content, "html")
 
+		noindex, err = m.parser.parse(bytes.NewReader(content), resource.location(), resource.Depth)
+		if err != nil {
+			fmt.Printf("Error parsing %s: %v\n", resource.LocalPath, err)
+		}
+	}
+	return noindex
+}
+
+// list prints where a resource would be saved. Only pages and
+// stylesheets are fetched, to a scratch file, to find what they link to.
+// It reports whether resource was queued again to be retried.
+func (m *Mirror) list(resource Resource) (requeued bool) {
+	if resource.Attempts == 0 {
+		fmt.Printf("%s -> %s\n", resource.URL, resource.LocalPath)
+	}
+
+	if !m.hasLinks(resource) {
+		m.counts.count(resource, nil)
+		return
+	}
+
+	original := resource
+	err := m.scan(&resource)
+	if m.retryLater(original, err) {
+		return true
+	}
+	var tooLarge *tooLargeError
+	if redirectedAway(err) || errors.As(err, &tooLarge) {
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error downloading %s: %v\n", resource.URL, err)
+		m.counts.count(resource, err)
+		m.recordFailure(resource.URL, err)
+		return
+	}
+	m.counts.count(resource, nil)
+	return
+}
+
+// hasLinks reports whether resource is worth fetching whole for its
+// links when nothing is kept: pages and stylesheets
+func (m *Mirror) hasLinks(resource Resource) bool {
+	var ext string
+	if u, err := url.Parse(resource.URL); err == nil {
+		ext = strings.ToLower(strings.TrimPrefix(path.Ext(u.Path), "."))
+	}
+	return resource.IsHTML && isPageExt(ext) || resource.IsCSS
+}
+
+// scan fetches resource to a scratch file, removed afterwards, and
+// queues what it links to
+func (m *Mirror) scan(resource *Resource) error {
+	f, err := os.CreateTemp("", "wget-list-urls-")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	resource.LocalPath = f.Name()
+	defer func() {
+		// AdjustExtension may have renamed it
+		os.Remove(f.Name())
+		os.Remove(resource.LocalPath)
+	}()
+
+	if err := m.fetch(resource); err != nil {
+		return err
+	}
+	resource.IsHTML = resource.IsHTML && isHTML(*resource)
+	m.parseLinks(*resource)
 	return nil
 }
 
+// process downloads a resource and, for pages and stylesheets, queues
+// what they link to. It reports whether resource was queued again to be
+// retried instead.
+func (m *Mirror) process(resource Resource) (requeued bool) {
+	if m.config.Spider {
+		return m.spider(resource)
+	}
+	if m.config.ListURLs {
+		return m.list(resource)
+	}
+	if m.config.ManifestFile != "" {
+		// As it stands once processed, however that ends
+		defer func() {
+			if !requeued {
+				m.record(resource)
+			}
+		}()
+	}
+
+	// Download the resource
+	original := resource
+	before := m.previousText(resource)
+	err := m.fetch(&resource)
+	if m.retryLater(original, err) {
+		return true
+	}
+	if errors.Is(err, errRedirectedOutOfScope) {
+		fmt.Printf("Skipping %s: %v (%s)\n", resource.URL, err, resource.RedirectedTo)
+	}
+	if redirectedAway(err) {
+		m.status.set(resource.URL, "redirected to "+resource.RedirectedTo)
+		return
+	}
+	var tooLarge *tooLargeError
+	if errors.As(err, &tooLarge) {
+		fmt.Printf("Skipping %s: %v\n", resource.URL, err)
+		m.status.set(resource.URL, err.Error())
+		m.parser.skip("size")
+		if tooLarge.size < 0 {
+			// What arrived before the limit was hit
+			m.remove(resource)
+		}
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error downloading %s: %v\n", resource.URL, err)
+		m.status.set(resource.URL, err.Error())
+		m.counts.count(resource, err)
+		m.recordFailure(resource.URL, err)
+		if m.config.OnFailure != nil {
+			m.config.OnFailure(resource, err)
+		}
+		return
+	}
+
+	// Only parse links out of what turned out to be HTML, whatever
+	// its URL looked like
+	resource.IsHTML = resource.IsHTML && isHTML(resource)
+	m.counts.count(resource, nil)
+
+	if m.keepsSaved() {
+		m.queue.ProcessLock.Lock()
+		m.queue.Saved[resource.URL] = resource.LocalPath
+		if resource.RedirectedTo != "" {
+			m.queue.Saved[resource.RedirectedTo] = resource.LocalPath
+		}
+		m.queue.ProcessLock.Unlock()
+	}
+
+	m.reportChanges(resource, before)
+
+	if m.config.AfterDownload != nil {
+		m.config.AfterDownload(resource)
+	}
+
+	if m.config.TranscodeUTF8 && !resource.NotModified {
+		if err := transcodeToUTF8(&resource); err != nil {
+			fmt.Printf("Error transcoding %s: %v\n", resource.LocalPath, err)
+		}
+	}
+
+	if m.config.Dedupe != "" && !resource.NotModified {
+		m.dedupe(&resource)
+	}
+
+	if m.config.StrictArchive && resource.IsHTML && isSoft404(resource.LocalPath) {
+		fmt.Printf("Soft 404 at %s\n", resource.URL)
+		m.recordFailure(resource.URL, errSoft404)
+	}
+
+	noindex := m.parseLinks(resource)
+
+	if noindex {
+		fmt.Printf("Removing %s since its robots meta tag says noindex\n", resource.LocalPath)
+		m.status.set(resource.URL, "noindex")
+		m.remove(resource)
+		return
+	}
+
+	// Pages outside the accept list were only needed for their links
+	if resource.Unaccepted && m.config.RemoveUnaccepted {
+		fmt.Printf("Removing %s since it should be rejected\n", resource.LocalPath)
+		m.remove(resource)
+		return
+	}
+	if m.config.DeleteAfter {
+		m.remove(resource)
+		return
+	}
+
+	// Convert links once the crawl is done; an unchanged page was
+	// converted by the run that downloaded it. The response head goes
+	// before the body once nothing more reads the file.
+	if m.config.ConvertLinks && !resource.NotModified && (resource.IsHTML || isCSS(resource)) {
+		m.toConvertLock.Lock()
+		m.toConvert = append(m.toConvert, resource)
+		m.toConvertLock.Unlock()
+	} else {
+		m.prependHeaders(resource)
+	}
+	return
+}
+
+// convertLinks converts the links of every page and stylesheet saved
+func (m *Mirror) convertLinks() {
+	converted := map[string]bool{}
+	for _, resource := range m.toConvert {
+		// A resumed crawl may have redone some
+		if converted[resource.LocalPath] {
+			continue
+		}
+		converted[resource.LocalPath] = true
+
+		var err error
+		if resource.IsHTML {
+			err = m.converter.convertPage(resource.LocalPath, resource.location(), resource.ContentType)
+		} else {
+			err = m.converter.ConvertCSSLinks(resource.LocalPath, resource.location())
+		}
+		if err != nil {
+			fmt.Printf("Error converting links in %s: %v\n", resource.LocalPath, err)
+		}
+		m.prependHeaders(resource)
+	}
+}
+
+// keepsSaved reports whether where each resource was saved is kept in
+// Queue.Saved: for converting links, which needs it for every resource
+// until the crawl is done, or for the manifest, which needs it only until
+// the resource is recorded
+func (m *Mirror) keepsSaved() bool {
+	return m.config.ConvertLinks || m.config.ManifestFile != ""
+}
+
+// remove deletes a saved resource, and the directories the mirror
+// created for it once they are empty. Links to it are left pointing at
+// the live site.
+func (m *Mirror) remove(resource Resource) {
+	m.queue.ProcessLock.Lock()
+	delete(m.queue.Saved, resource.URL)
+	delete(m.queue.Saved, resource.RedirectedTo)
+	m.queue.ProcessLock.Unlock()
+
+	file := resource.LocalPath
+	os.Remove(file)
+	os.Remove(file + ".headers")
+	outputDir := filepath.Clean(m.config.OutputDir)
+	for dir := filepath.Dir(file); dir != outputDir && dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			return
+		}
+	}
+}
+
+// unconverted returns the file holding resource as downloaded. A page
+// left unchanged since an earlier run with -k has had its links
+// converted, so its -K backup is parsed instead if there is one.
+func (m *Mirror) unconverted(resource Resource) string {
+	if resource.NotModified && m.config.ConvertLinks {
+		if _, err := os.Stat(resource.LocalPath + ".orig"); err == nil {
+			return resource.LocalPath + ".orig"
+		}
+	}
+	return resource.LocalPath
+}
+
+// due claims a request slot for resource's host and returns 0, or
+// returns how long until the host is due
+func (m *Mirror) due(resource Resource) time.Duration {
+	u, err := url.Parse(resource.URL)
+	if err != nil {
+		return 0
+	}
+	return m.hosts.reserve(u)
+}
+
+// fetch downloads resource, whose host must be due, retrying failures
+// as Retry says
+func (m *Mirror) fetch(resource *Resource) error {
+	return m.retry(resource, m.downloader.downloadResource)
+}
+
+// retry makes one attempt at request for resource. A failure to be
+// retried, as Retry says, holds the host off for the backoff and returns
+// a *retryLaterError, for the caller to put the resource back on the
+// queue rather than have a worker wait out the backoff. A 429 or 503
+// with Retry-After holds the host off for at least that long, and is
+// tried once more even without Retry.
+func (m *Mirror) retry(resource *Resource, request func(context.Context, *Resource) error) error {
+	u, err := url.Parse(resource.URL)
+	if err != nil {
+		return err
+	}
+	var strategy RetryStrategy
+	if m.config.Retry != nil {
+		strategy = m.config.Retry.For(u.Hostname())
+	}
+
+	attempt := resource.Attempts + 1
+	ctx, cancel := context.Background(), context.CancelFunc(func() {})
+	if strategy != nil && strategy.Timeout() > 0 {
+		ctx, cancel = context.WithTimeout(ctx, strategy.Timeout())
+	}
+	err = request(ctx, resource)
+	cancel()
+
+	var tooLarge *tooLargeError
+	if err == nil || redirectedAway(err) || errors.As(err, &tooLarge) {
+		return err
+	}
+
+	var se *statusError
+	code := 0
+	if errors.As(err, &se) {
+		code = se.code
+	}
+	var delay time.Duration
+	retry := false
+	if strategy != nil {
+		delay, retry = strategy.Retry(attempt, code, err)
+	}
+	if se != nil && se.retryAfter > 0 &&
+		(code == http.StatusTooManyRequests || code == http.StatusServiceUnavailable) {
+		retry = retry || attempt == 1
+		if se.retryAfter > delay {
+			delay = se.retryAfter
+		}
+	}
+	if !retry {
+		return err
+	}
+	fmt.Printf("%s: %v, retrying in %v\n", resource.URL, err, delay)
+	m.hosts.backoff(u, delay)
+	return &retryLaterError{err: err, delay: delay, attempts: attempt}
+}
+
+// retryLaterError is a failed attempt to be retried after delay
+type retryLaterError struct {
+	err      error
+	delay    time.Duration
+	attempts int // Failed attempts so far
+}
+
+func (e *retryLaterError) Error() string {
+	return fmt.Sprintf("%v, retrying in %v", e.err, e.delay)
+}
+
+func (e *retryLaterError) Unwrap() error {
+	return e.err
+}
+
+// retryLater puts original, the resource as taken off the queue, back
+// on it if err is a failure to be retried, and reports whether it did
+func (m *Mirror) retryLater(original Resource, err error) bool {
+	var later *retryLaterError
+	if !errors.As(err, &later) {
+		return false
+	}
+	original.Attempts = later.attempts
+	m.queue.pushLater(original, later.delay)
+	return true
+}
+
+// queuePages queues every page of the page list for download
+func (m *Mirror) queuePages() {
+	for _, rawURL := range m.config.PageList {
+		u, err := url.Parse(rawURL)
+		if err != nil || !u.IsAbs() {
+			fmt.Printf("Skipping invalid page URL %q\n", rawURL)
+			continue
+		}
+		u = normalizeURL(u, m.config.SortQuery)
+		if !m.queue.Processed.Add(u.String()) {
+			continue
+		}
+		m.queue.Push(Resource{
+			URL:       u.String(),
+			LocalPath: m.parser.localPath(u),
+			IsHTML:    true,
+		})
+	}
+}
+
+// Pending returns the number of resources waiting in the queue
+func (m *Mirror) Pending() int {
+	return m.queue.waiting()
+}
+
+// BytesWritten returns the number of bytes saved to disk by the mirror
+func (m *Mirror) BytesWritten() int64 {
+	return m.downloader.BytesWritten()
+}
+
 // processURL normalizes and validates a URL
 func (m *Mirror) processURL(rawURL string) (*url.URL, error) {
 	if rawURL == "" || strings.HasPrefix(rawURL, "#") {
@@ -0,0 +1,70 @@
+package mirror
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// defaultPorts are the ports left out of normalized URLs
+var defaultPorts = map[string]string{"http": "80", "https": "443"}
+
+// normalizeURL returns u in the one form used to key the queue and the
+// saved files, so that spellings of the same URL are fetched once: the
+// scheme and host lowercased, the default port and the fragment dropped,
+// dot segments resolved and an empty path made "/". With sortQuery the
+// query parameters are also put in order.
+func normalizeURL(u *url.URL, sortQuery bool) *url.URL {
+	n := *u
+	n.Scheme = strings.ToLower(n.Scheme)
+	n.Host = strings.ToLower(n.Host)
+	if port := n.Port(); port != "" && port == defaultPorts[n.Scheme] {
+		n.Host = strings.TrimSuffix(n.Host, ":"+port)
+	}
+	n.Fragment = ""
+	n.RawFragment = ""
+
+	n.Path = removeDotSegments(n.Path)
+	if n.RawPath != "" {
+		n.RawPath = removeDotSegments(n.RawPath)
+	}
+	if n.Path == "" && n.Opaque == "" {
+		n.Path = "/"
+	}
+
+	if sortQuery && n.RawQuery != "" {
+		params := strings.Split(n.RawQuery, "&")
+		sort.Strings(params)
+		n.RawQuery = strings.Join(params, "&")
+	}
+	return &n
+}
+
+// removeDotSegments resolves the "." and ".." segments of path p as RFC
+// 3986 does, never going above the root
+func removeDotSegments(p string) string {
+	if !strings.Contains(p, ".") {
+		return p
+	}
+	segments := strings.Split(p, "/")
+	var out []string
+	for i, segment := range segments {
+		last := i == len(segments)-1
+		switch segment {
+		case ".":
+			if last {
+				out = append(out, "")
+			}
+		case "..":
+			if len(out) > 1 {
+				out = out[:len(out)-1]
+			}
+			if last {
+				out = append(out, "")
+			}
+		default:
+			out = append(out, segment)
+		}
+	}
+	return strings.Join(out, "/")
+}
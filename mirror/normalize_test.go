@@ -0,0 +1,27 @@
+package mirror
+
+import "testing"
+
+func TestRemoveDotSegments(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"", ""},
+		{"/", "/"},
+		{"/a/b.c/d", "/a/b.c/d"},
+		{"/a/./b", "/a/b"},
+		{"/a/b/.", "/a/b/"},
+		{"/a/b/..", "/a/"},
+		{"/a/b/c/./../../g", "/a/g"},
+		{"/a/../../b", "/b"},
+		{"/..", "/"},
+		{"/../a", "/a"},
+		{"mid/content=5/../6", "mid/6"},
+		{"/a/..b/c", "/a/..b/c"},
+	}
+	for _, tt := range tests {
+		if got := removeDotSegments(tt.in); got != tt.want {
+			t.Errorf("removeDotSegments(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -3,16 +3,26 @@ package mirror
 import (
 	"golang.org/x/net/html"
 	"io"
+	"net/http"
 	"net/url"
+	"os"
 	"path"
 	"strings"
+	"sync"
 )
 
 // Parser handles HTML parsing and link extraction
 type Parser struct {
 	baseURL      *url.URL
+	seedHosts    map[string]bool // Hosts of the seed and the page list; others need SpanHosts
 	config       *Config
 	queue        *Queue
+	robots       *robotsCache // nil when IgnoreRobots is set
+	full         func() bool  // Reports when nothing more is to be queued; nil for never
+	follow       selector     // With FollowSelector, where the links to follow are
+
+	skipped     map[string]int64 // Links not followed, by the filter ruling them out
+	skippedLock sync.Mutex
 }
 
 // NewParser creates a new Parser instance
@@ -21,37 +31,161 @@ func NewParser(baseURL string, config *Config, queue *Queue) (*Parser, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &Parser{
-		baseURL: parsedURL,
-		config:  config,
-		queue:   queue,
-	}, nil
+	p := &Parser{
+		baseURL:   parsedURL,
+		seedHosts: map[string]bool{parsedURL.Host: true},
+		config:    config,
+		queue:     queue,
+		skipped:   map[string]int64{},
+	}
+	for _, rawURL := range config.PageList {
+		if u, err := url.Parse(rawURL); err == nil && u.IsAbs() {
+			p.seedHosts[normalizeURL(u, config.SortQuery).Host] = true
+		}
+	}
+	if config.FollowSelector != "" {
+		if p.follow, err = parseSelector(config.FollowSelector); err != nil {
+			return nil, err
+		}
+	}
+	if !config.IgnoreRobots {
+		client := config.Client
+		if client == nil {
+			client = &http.Client{}
+		}
+		userAgent := config.UserAgent
+		if userAgent == "" {
+			userAgent = "Go-http-client/1.1"
+		}
+		p.robots = newRobotsCache(config, client, userAgent)
+	}
+	return p, nil
 }
 
-// Parse processes an HTML document and extracts links
-func (p *Parser) Parse(r io.Reader) error {
+// Parse processes an HTML document fetched from pageURL, depth links away
+// from the seed, and extracts links, resolving relative ones against
+// pageURL
+func (p *Parser) Parse(r io.Reader, pageURL string, depth int) error {
+	_, err := p.parse(r, pageURL, depth)
+	return err
+}
+
+// parse is Parse, also reporting whether, with HonorNofollow, the page's
+// robots meta tag asks for it not to be kept
+func (p *Parser) parse(r io.Reader, pageURL string, depth int) (noindex bool, err error) {
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return false, err
+	}
+
 	doc, err := html.Parse(r)
 	if err != nil {
-		return err
+		return false, err
 	}
+	base = documentBase(doc, base)
 
-	var f func(*html.Node)
-	f = func(n *html.Node) {
+	var nofollow bool
+	if p.config.HonorNofollow {
+		noindex, nofollow = robotsMeta(doc)
+	}
+
+	var f func(n *html.Node, inScope bool)
+	f = func(n *html.Node, inScope bool) {
 		if n.Type == html.ElementNode {
-			var attr string
-			switch n.Data {
-			case "a", "link":
-				attr = "href"
-			case "img", "script":
-				attr = "src"
-			}
+			// With FollowSelector, only links inside the elements it
+			// matches are followed
+			inScope = inScope || p.follow == nil || p.follow.match(n)
 
-			if attr != "" {
-				for _, a := range n.Attr {
-					if a.Key == attr {
-						p.processURL(a.Val)
-						break
+			// In page-list mode only the requisites of each page are
+			// fetched; links to other pages are not followed
+			requisite := isRequisite(n)
+			if len(p.config.PageList) == 0 || requisite {
+				for _, link := range elementLinks(n) {
+					if !requisite && !inScope {
+						p.skip("selector")
+						continue
+					}
+					if !requisite && p.config.HonorNofollow && (nofollow || hasRel(n, "nofollow")) {
+						p.skip("nofollow")
+						continue
 					}
+					p.processURL(link, base, pageURL, depth+1, requisite)
+				}
+			}
+
+			// Lazily loaded images keep their URLs in attributes
+			// such as data-src until a script swaps them in
+			for _, link := range lazyLinks(n, p.config.LazyAttrs) {
+				p.processURL(link, base, pageURL, depth+1, true)
+			}
+
+			// Inline styles are part of the page, so their images
+			// and fonts are requisites
+			for _, link := range inlineCSSLinks(n) {
+				p.processURL(link, base, pageURL, depth+1, true)
+			}
+
+			// Some pages only lead on through a meta refresh or a
+			// script, so those targets are followed like redirects
+			for _, link := range clientRedirects(n) {
+				p.processURL(link, base, pageURL, depth+1, false)
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			f(c, inScope)
+		}
+	}
+	f(doc, false)
+	return noindex, nil
+}
+
+// documentBase returns the URL relative links in doc, fetched from page,
+// resolve against: that of its first <base href>, or page itself
+func documentBase(doc *html.Node, page *url.URL) *url.URL {
+	n := findBase(doc)
+	if n == nil {
+		return page
+	}
+	ref, err := url.Parse(strings.TrimSpace(attrValue(n, "href")))
+	if err != nil {
+		return page
+	}
+	base := page.ResolveReference(ref)
+	if base.Scheme != "http" && base.Scheme != "https" {
+		return page
+	}
+	return base
+}
+
+// findBase returns the first <base> element of doc with an href, or nil
+func findBase(n *html.Node) *html.Node {
+	if n.Type == html.ElementNode && n.Data == "base" {
+		if _, ok := attr(n, "href"); ok {
+			return n
+		}
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findBase(c); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// robotsMeta reads the noindex and nofollow directives of the robots
+// meta tags in doc. "none" means both.
+func robotsMeta(doc *html.Node) (noindex, nofollow bool) {
+	var f func(*html.Node)
+	f = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "meta" && strings.EqualFold(attrValue(n, "name"), "robots") {
+			for _, directive := range strings.Split(attrValue(n, "content"), ",") {
+				switch strings.ToLower(strings.TrimSpace(directive)) {
+				case "noindex":
+					noindex = true
+				case "nofollow":
+					nofollow = true
+				case "none":
+					noindex, nofollow = true, true
 				}
 			}
 		}
@@ -60,16 +194,41 @@ func (p *Parser) Parse(r io.Reader) error {
 		}
 	}
 	f(doc)
-	return nil
+	return noindex, nofollow
+}
+
+// hasRel reports whether element n's rel attribute lists value
+func hasRel(n *html.Node, value string) bool {
+	for _, rel := range strings.Fields(attrValue(n, "rel")) {
+		if strings.EqualFold(rel, value) {
+			return true
+		}
+	}
+	return false
 }
 
-// processURL handles a discovered URL
-func (p *Parser) processURL(rawURL string) {
+// processURL handles a URL discovered on the page at referrer, relative
+// to base, which puts it depth links away from the seed. requisite is
+// set for resources the page needs to render.
+func (p *Parser) processURL(rawURL string, base *url.URL, referrer string, depth int, requisite bool) {
+	rawURL = trimURL(rawURL)
+
 	// Skip empty URLs and anchors
 	if rawURL == "" || strings.HasPrefix(rawURL, "#") {
 		return
 	}
 
+	// With --page-requisites, what a page needs to render is fetched
+	// wherever it lives and however deep the page is
+	needed := requisite && p.config.PageRequisites
+
+	// Skip links beyond the recursion limit. They are not marked as
+	// processed, so a shorter path to the same URL can still queue it.
+	if p.config.MaxDepth > 0 && depth > p.config.MaxDepth && !needed {
+		p.skip("depth")
+		return
+	}
+
 	// Parse the URL
 	u, err := url.Parse(rawURL)
 	if err != nil {
@@ -78,47 +237,410 @@ func (p *Parser) processURL(rawURL string) {
 
 	// Make absolute URL if relative
 	if !u.IsAbs() {
-		u = p.baseURL.ResolveReference(u)
+		u = base.ResolveReference(u)
+	}
+	// A fragment names a part of the same resource
+	u.Fragment = ""
+
+	// Apply the user's rewrite rules before anything else looks at it
+	if u = rewriteURL(p.config.Rewrites, u); u == nil {
+		p.skip("rewrite")
+		return
+	}
+	u = normalizeURL(u, p.config.SortQuery)
+
+	// Keep to the crawl's hosts, directories and file types
+	reason, unaccepted := p.scope(u, requisite)
+	if reason != "" {
+		p.skip(reason)
+		return
+	}
+
+	if p.config.BrokenLinks != "" || p.config.Spider {
+		p.queue.addReferrer(u.String(), referrer)
 	}
 
-	// Skip if different host
-	if u.Host != p.baseURL.Host {
+	if p.full != nil && p.full() {
+		p.skip("quota")
 		return
 	}
 
+	// Add to queue if not processed
+	p.queue.ProcessLock.RLock()
+	if !p.queue.Processed.Has(u.String()) {
+		p.queue.ProcessLock.RUnlock()
+		p.queue.ProcessLock.Lock()
+		if p.queue.Processed.Add(u.String()) {
+			p.prefetch(u.Hostname())
+			p.queue.Push(Resource{
+				URL:        u.String(),
+				LocalPath:  p.localPath(u),
+				IsHTML:     !requisite,
+				IsCSS:      strings.EqualFold(path.Ext(u.Path), ".css"),
+				Depth:      depth,
+				Unaccepted: unaccepted,
+				Referrer:   referrer,
+				Requisite:  requisite,
+			})
+		}
+		p.queue.ProcessLock.Unlock()
+	} else {
+		p.queue.ProcessLock.RUnlock()
+	}
+}
+
+// scope applies the filters that decide whether u belongs to the crawl,
+// wherever it was found: the hosts, directories, file types, URL
+// patterns and robots.txt. It returns the name of the filter ruling u
+// out, or "" and whether u is a page fetched only for its links.
+func (p *Parser) scope(u *url.URL, requisite bool) (reason string, unaccepted bool) {
+	// With --page-requisites, what a page needs to render is fetched
+	// wherever it lives
+	needed := requisite && p.config.PageRequisites
+
+	// Skip other hosts unless spanning to them is allowed. Scope is kept
+	// to the seed, whatever host the page with the link is on.
+	if !p.seedHosts[u.Host] {
+		if p.excludedDomain(u.Hostname()) || !(needed || p.spanTo(u.Hostname())) {
+			return "host", false
+		}
+	}
+
+	// Stay below the starting directory
+	if p.config.NoParent && !needed && !strings.HasPrefix(u.Path, p.startDir()) {
+		return "parent", false
+	}
+
 	// Check excluded paths
 	for _, exclude := range p.config.ExcludePaths {
 		if strings.HasPrefix(u.Path, exclude) {
-			return
+			return "exclude", false
 		}
 	}
 
+	// Check included directories
+	if len(p.config.IncludePaths) > 0 && !needed && !inDirectories(u.Path, p.config.IncludePaths) {
+		return "include", false
+	}
+
 	// Check rejected file types
 	ext := strings.ToLower(path.Ext(u.Path))
 	if ext != "" {
 		ext = ext[1:] // remove dot
 		for _, reject := range p.config.RejectTypes {
 			if ext == reject {
-				return
+				return "reject", false
 			}
 		}
 	}
 
-	// Add to queue if not processed
-	p.queue.ProcessLock.RLock()
-	if !p.queue.Processed[u.String()] {
-		p.queue.ProcessLock.RUnlock()
-		p.queue.ProcessLock.Lock()
-		if !p.queue.Processed[u.String()] {
-			p.queue.Processed[u.String()] = true
-			p.queue.Resources <- Resource{
-				URL:       u.String(),
-				LocalPath: path.Join(p.config.OutputDir, u.Host, u.Path),
-				IsHTML:    ext == "html" || ext == "htm",
+	// Check accepted file types and URL patterns. Pages are still
+	// fetched when they are not accepted, since the accepted files are
+	// found through them.
+	if p.config.RejectRegex != nil && p.config.RejectRegex.MatchString(u.String()) {
+		return "reject", false
+	}
+	unaccepted = len(p.config.AcceptTypes) > 0 && !containsFold(p.config.AcceptTypes, ext)
+	if p.config.AcceptRegex != nil && !p.config.AcceptRegex.MatchString(u.String()) {
+		unaccepted = true
+	}
+	if unaccepted && (requisite || !isPageExt(ext)) {
+		return "accept", false
+	}
+
+	// Honor robots.txt
+	if p.robots != nil && !p.robots.allowed(u) {
+		return "robots", false
+	}
+	return "", unaccepted
+}
+
+// trimURL strips the whitespace HTML allows around a URL in an
+// attribute. Left in, it turns a protocol-relative //host/path into a
+// path on the page's own host.
+func trimURL(rawURL string) string {
+	return strings.Trim(rawURL, " \t\n\f\r")
+}
+
+// skip counts a link not followed because of the filter named reason
+func (p *Parser) skip(reason string) {
+	p.skippedLock.Lock()
+	defer p.skippedLock.Unlock()
+	p.skipped[reason]++
+}
+
+// spanTo reports whether links may lead to host, a host other than the
+// seed's
+func (p *Parser) spanTo(host string) bool {
+	if !p.config.SpanHosts {
+		return false
+	}
+	if len(p.config.Domains) == 0 {
+		return true
+	}
+	for _, domain := range p.config.Domains {
+		if inDomain(host, domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// excludedDomain reports whether host is ruled out by ExcludeDomains
+func (p *Parser) excludedDomain(host string) bool {
+	for _, domain := range p.config.ExcludeDomains {
+		if inDomain(host, domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// inDomain reports whether host is domain or one of its subdomains
+func inDomain(host, domain string) bool {
+	host = strings.ToLower(host)
+	domain = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(domain), "."))
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+// startDir returns the directory of the base URL, with a trailing slash
+func (p *Parser) startDir() string {
+	dir := p.baseURL.Path
+	if !strings.HasSuffix(dir, "/") {
+		dir = path.Dir(dir)
+		if !strings.HasSuffix(dir, "/") {
+			dir += "/"
+		}
+	}
+	return dir
+}
+
+// prefetch hands a host to the Prefetch hook the first time it is queued
+func (p *Parser) prefetch(host string) {
+	if p.config.Prefetch == nil || p.queue.Hosts[host] {
+		return
+	}
+	p.queue.Hosts[host] = true
+	go p.config.Prefetch(host)
+}
+
+// localPath returns where the resource at u is saved
+func (p *Parser) localPath(u *url.URL) string {
+	return localPath(p.config, u)
+}
+
+// inDirectories reports whether urlPath lies under one of dirs. A
+// directory may contain shell wildcards, each matching within a single
+// path segment, e.g. "/docs/*/api".
+func inDirectories(urlPath string, dirs []string) bool {
+	segments := strings.Split(strings.Trim(urlPath, "/"), "/")
+	for _, dir := range dirs {
+		dir = strings.TrimSpace(dir)
+		if !strings.ContainsAny(dir, "*?[") {
+			if strings.HasPrefix(urlPath, dir) {
+				return true
+			}
+			continue
+		}
+
+		pattern := strings.Split(strings.Trim(dir, "/"), "/")
+		if len(pattern) > len(segments) {
+			continue
+		}
+		prefix := strings.Join(segments[:len(pattern)], "/")
+		if ok, _ := path.Match(strings.Join(pattern, "/"), prefix); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// isHTML reports whether a downloaded resource is an HTML page, going by
+// its Content-Type or, if the server sent none, by its content
+func isHTML(resource Resource) bool {
+	contentType := resource.ContentType
+	if contentType == "" || strings.HasPrefix(contentType, "application/octet-stream") {
+		f, err := os.Open(resource.LocalPath)
+		if err != nil {
+			return false
+		}
+		defer f.Close()
+		head := make([]byte, 512)
+		n, _ := io.ReadFull(f, head)
+		contentType = http.DetectContentType(head[:n])
+	}
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	switch strings.ToLower(strings.TrimSpace(mediaType)) {
+	case "text/html", "application/xhtml+xml":
+		return true
+	}
+	return false
+}
+
+// pageExts are the extensions of URLs that are likely HTML pages
+var pageExts = []string{"", "html", "htm", "xhtml", "shtml", "php", "asp", "aspx", "jsp", "cgi"}
+
+// isPageExt reports whether ext, without its dot, is likely an HTML page
+func isPageExt(ext string) bool {
+	return containsFold(pageExts, ext)
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(strings.TrimPrefix(strings.TrimSpace(item), "."), s) {
+			return true
+		}
+	}
+	return false
+}
+
+// urlAttrs returns the attributes of element n that hold a single URL
+func urlAttrs(n *html.Node) []string {
+	switch n.Data {
+	case "a", "link":
+		return []string{"href"}
+	case "img", "script", "source", "audio", "track", "embed", "iframe", "frame":
+		return []string{"src"}
+	case "video":
+		return []string{"src", "poster"}
+	case "object":
+		return []string{"data"}
+	}
+	return nil
+}
+
+// hasSrcset reports whether element n can carry a srcset attribute
+func hasSrcset(n *html.Node) bool {
+	return n.Data == "img" || n.Data == "source"
+}
+
+// elementLinks returns every URL referenced by element n, including each
+// candidate of a srcset
+func elementLinks(n *html.Node) []string {
+	var links []string
+	for _, attr := range urlAttrs(n) {
+		for _, a := range n.Attr {
+			if a.Key == attr {
+				links = append(links, a.Val)
+				break
+			}
+		}
+	}
+	if hasSrcset(n) {
+		for _, a := range n.Attr {
+			if a.Key == "srcset" {
+				for _, c := range parseSrcset(a.Val) {
+					links = append(links, c.url)
+				}
+			}
+		}
+	}
+	return links
+}
+
+// isSrcsetAttr reports whether the lazy-loading attribute name holds a
+// srcset rather than a single URL
+func isSrcsetAttr(name string) bool {
+	return strings.HasSuffix(strings.ToLower(name), "srcset")
+}
+
+// lazyLinks returns the URLs element n holds in any of attrs
+func lazyLinks(n *html.Node, attrs []string) []string {
+	var links []string
+	for _, name := range attrs {
+		value, ok := attr(n, strings.ToLower(name))
+		if !ok {
+			continue
+		}
+		if !isSrcsetAttr(name) {
+			links = append(links, value)
+			continue
+		}
+		for _, c := range parseSrcset(value) {
+			links = append(links, c.url)
+		}
+	}
+	return links
+}
+
+// inlineCSSLinks returns the URLs referenced by the style attribute of
+// element n, or by its contents if it is a <style> element
+func inlineCSSLinks(n *html.Node) []string {
+	var links []string
+	for _, a := range n.Attr {
+		if a.Key == "style" {
+			links = append(links, cssLinks(a.Val)...)
+		}
+	}
+	if n.Data == "style" {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == html.TextNode {
+				links = append(links, cssLinks(c.Data)...)
+			}
+		}
+	}
+	return links
+}
+
+// srcsetCandidate is one "url [descriptor]" entry of a srcset
+type srcsetCandidate struct {
+	url        string
+	descriptor string // e.g. "2x" or "640w"; may be empty
+}
+
+// parseSrcset splits a srcset attribute into its candidates. As in the
+// HTML spec, a URL runs to the next whitespace, so it may itself contain
+// commas, and a comma right after it ends a candidate with no descriptor.
+func parseSrcset(srcset string) []srcsetCandidate {
+	const space = " \t\n\r\f"
+	var candidates []srcsetCandidate
+	for rest := srcset; ; {
+		rest = strings.TrimLeft(rest, space+",")
+		if rest == "" {
+			return candidates
+		}
+
+		end := strings.IndexAny(rest, space)
+		if end < 0 {
+			end = len(rest)
+		}
+		candidate := srcsetCandidate{url: rest[:end]}
+		rest = rest[end:]
+
+		if strings.HasSuffix(candidate.url, ",") {
+			candidate.url = strings.TrimRight(candidate.url, ",")
+		} else {
+			// The descriptor runs to the next comma
+			end = strings.IndexByte(rest, ',')
+			if end < 0 {
+				end = len(rest)
+			}
+			candidate.descriptor = strings.Join(strings.Fields(rest[:end]), " ")
+			rest = rest[end:]
+		}
+		candidates = append(candidates, candidate)
+	}
+}
+
+// isRequisite reports whether an element references something needed to
+// render the page (as opposed to a link to another page). Frames are
+// pages of their own, so they are crawled like links.
+func isRequisite(n *html.Node) bool {
+	switch n.Data {
+	case "img", "script", "source", "audio", "video", "track", "embed", "object":
+		return true
+	case "link":
+		for _, a := range n.Attr {
+			if a.Key != "rel" {
+				continue
+			}
+			for _, rel := range strings.Fields(strings.ToLower(a.Val)) {
+				switch rel {
+				case "stylesheet", "icon", "shortcut", "apple-touch-icon", "preload", "modulepreload", "manifest":
+					return true
+				}
 			}
 		}
-		p.queue.ProcessLock.Unlock()
-	} else {
-		p.queue.ProcessLock.RUnlock()
 	}
+	return false
 }
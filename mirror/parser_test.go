@@ -0,0 +1,109 @@
+package mirror
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParseSrcset(t *testing.T) {
+	tests := []struct {
+		srcset string
+		want   []srcsetCandidate
+	}{
+		{"", nil},
+		{"a.png", []srcsetCandidate{{"a.png", ""}}},
+		{"a.png 1x, b.png 2x", []srcsetCandidate{{"a.png", "1x"}, {"b.png", "2x"}}},
+		{"  a.png  640w ,b.png", []srcsetCandidate{{"a.png", "640w"}, {"b.png", ""}}},
+		{"a.png, b.png 2x", []srcsetCandidate{{"a.png", ""}, {"b.png", "2x"}}},
+		{"a,b.png 1x,c.png", []srcsetCandidate{{"a,b.png", "1x"}, {"c.png", ""}}},
+		{"a.png 100w 2x", []srcsetCandidate{{"a.png", "100w 2x"}}},
+		{",, a.png,,", []srcsetCandidate{{"a.png", ""}}},
+	}
+	for _, tt := range tests {
+		if got := parseSrcset(tt.srcset); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("parseSrcset(%q) = %v, want %v", tt.srcset, got, tt.want)
+		}
+	}
+}
+
+// parsedLinks parses page, found at pageURL, and reports which of urls
+// were queued
+func parsedLinks(t *testing.T, config Config, pageURL, page string, urls ...string) map[string]bool {
+	t.Helper()
+	config.IgnoreRobots = true
+	q := NewQueue()
+	p, err := NewParser(config.URL, &config, q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Parse(strings.NewReader(page), pageURL, 0); err != nil {
+		t.Fatal(err)
+	}
+	queued := map[string]bool{}
+	for _, u := range urls {
+		queued[u] = q.Processed.Has(u)
+	}
+	return queued
+}
+
+func TestParserHostScope(t *testing.T) {
+	page := `<a href="http://example.com/a">a</a>
+<a href="http://docs.example.com/b">b</a>
+<a href="http://other.org/c">c</a>`
+	tests := []struct {
+		name   string
+		config Config
+		page   string // where the page was found
+		want   map[string]bool
+	}{
+		{"seed host only", Config{URL: "http://example.com/"}, "http://example.com/",
+			map[string]bool{"http://example.com/a": true, "http://docs.example.com/b": false, "http://other.org/c": false}},
+		{"page spanned to", Config{URL: "http://example.com/", SpanHosts: true, Domains: []string{"docs.example.com"}}, "http://docs.example.com/",
+			map[string]bool{"http://example.com/a": true, "http://docs.example.com/b": true, "http://other.org/c": false}},
+		{"page on another host", Config{URL: "http://example.com/"}, "http://other.org/",
+			map[string]bool{"http://example.com/a": true, "http://docs.example.com/b": false, "http://other.org/c": false}},
+		{"excluded domain", Config{URL: "http://example.com/", SpanHosts: true, ExcludeDomains: []string{"other.org"}}, "http://example.com/",
+			map[string]bool{"http://example.com/a": true, "http://docs.example.com/b": true, "http://other.org/c": false}},
+	}
+	for _, tt := range tests {
+		var urls []string
+		for u := range tt.want {
+			urls = append(urls, u)
+		}
+		got := parsedLinks(t, tt.config, tt.page, page, urls...)
+		for u, want := range tt.want {
+			if got[u] != want {
+				t.Errorf("%s: %s queued = %v, want %v", tt.name, u, got[u], want)
+			}
+		}
+	}
+
+	// Every host of a page list is a seed host
+	config := Config{URL: "http://example.com/", PageList: []string{"http://example.com/", "http://OTHER.org:80/x"}}
+	got := parsedLinks(t, config, "http://other.org/x", `<img src="/i.png"><img src="http://cdn.net/j.png">`,
+		"http://other.org/i.png", "http://cdn.net/j.png")
+	if !got["http://other.org/i.png"] || got["http://cdn.net/j.png"] {
+		t.Errorf("page list requisites queued = %v, want only the one on a listed host", got)
+	}
+}
+
+func TestParserBaseHrefScope(t *testing.T) {
+	// A <base href> on another host moves where relative links point,
+	// not the crawl onto that host
+	page := `<base href="http://cdn.net/static/">
+<a href="guide.html">guide</a>
+<a href="http://example.com/about">about</a>`
+	got := parsedLinks(t, Config{URL: "http://example.com/"}, "http://example.com/", page,
+		"http://cdn.net/static/guide.html", "http://example.com/guide.html", "http://example.com/about")
+	want := map[string]bool{
+		"http://cdn.net/static/guide.html": false,
+		"http://example.com/guide.html":    false,
+		"http://example.com/about":         true,
+	}
+	for u, queued := range want {
+		if got[u] != queued {
+			t.Errorf("%s queued = %v, want %v", u, got[u], queued)
+		}
+	}
+}
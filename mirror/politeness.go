@@ -0,0 +1,105 @@
+package mirror
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxRetryAfter caps how long a Retry-After header can hold up a host
+const maxRetryAfter = 10 * time.Minute
+
+// statusError reports a resource served with a status other than 200
+type statusError struct {
+	code       int
+	retryAfter time.Duration // from a Retry-After header; 0 if absent
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("received status code %d", e.code)
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as an
+// HTTP date
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	var d time.Duration
+	if seconds, err := strconv.Atoi(value); err == nil {
+		d = time.Duration(seconds) * time.Second
+	} else if t, err := http.ParseTime(value); err == nil {
+		d = time.Until(t)
+	}
+	if d < 0 {
+		return 0
+	}
+	if d > maxRetryAfter {
+		return maxRetryAfter
+	}
+	return d
+}
+
+// hostScheduler spaces out requests to each host: by HostDelay plus up
+// to HostJitter, by the Crawl-delay robots.txt asks for if that is
+// longer, and by the Retry-After of a 429 or 503. Workers ask it whether
+// a resource's host is due instead of sleeping, so a slow origin does
+// not hold up the others.
+type hostScheduler struct {
+	delay      time.Duration
+	jitter     time.Duration
+	crawlDelay func(*url.URL) time.Duration // nil when robots.txt is ignored
+	mu         sync.Mutex
+	next       map[string]time.Time // earliest time of the next request
+}
+
+func newHostScheduler(config *Config, crawlDelay func(*url.URL) time.Duration) *hostScheduler {
+	return &hostScheduler{
+		delay:      config.HostDelay,
+		jitter:     config.HostJitter,
+		crawlDelay: crawlDelay,
+		next:       map[string]time.Time{},
+	}
+}
+
+// gap returns the time to leave between two requests to u's host
+func (s *hostScheduler) gap(u *url.URL) time.Duration {
+	gap := s.delay
+	if s.crawlDelay != nil {
+		if d := s.crawlDelay(u); d > gap {
+			gap = d
+		}
+	}
+	if s.jitter > 0 {
+		gap += time.Duration(rand.Int63n(int64(s.jitter)))
+	}
+	return gap
+}
+
+// reserve claims the next request to u's host and returns 0 if the host
+// is due, or else returns how long until it is
+func (s *hostScheduler) reserve(u *url.URL) time.Duration {
+	gap := s.gap(u)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if next := s.next[u.Host]; next.After(now) {
+		return next.Sub(now)
+	}
+	s.next[u.Host] = now.Add(gap)
+	return 0
+}
+
+// backoff holds off requests to u's host for d
+func (s *hostScheduler) backoff(u *url.URL, d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if until := time.Now().Add(d); until.After(s.next[u.Host]) {
+		s.next[u.Host] = until
+	}
+}
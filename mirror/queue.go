@@ -0,0 +1,138 @@
+package mirror
+
+import (
+	"fmt"
+	"time"
+)
+
+// Push adds r to the queue. It counts as pending until Done is called
+// for it. Push never blocks: resources wait in the backlog for the
+// workers to take them off Resources.
+func (q *Queue) Push(r Resource) {
+	q.feeding.Do(func() { go q.feed() })
+	q.frontierLock.Lock()
+	q.pending++
+	q.backlog.push(r)
+	q.frontierCond.Signal()
+	q.frontierLock.Unlock()
+}
+
+// feed moves resources from the backlog onto Resources in crawl order,
+// and closes Resources once the queue is drained. Every resource pushed
+// is pending until it is finished, shelved or lost, including while it
+// is on Resources, in a worker's hands or waiting to be pushed again
+// later, so the queue is drained exactly when no work is left that could
+// push more.
+func (q *Queue) feed() {
+	for {
+		q.frontierLock.Lock()
+		for q.backlog.len() == 0 && !q.drained() {
+			q.frontierCond.Wait()
+		}
+		if q.backlog.len() == 0 {
+			q.backlog.close()
+			q.frontierLock.Unlock()
+			close(q.Resources)
+			return
+		}
+		r, lost, ok := q.backlog.pop()
+		if ok {
+			q.frontier[r.URL] = r
+		}
+		q.frontierLock.Unlock()
+
+		for i := 0; i < lost; i++ {
+			q.Done()
+		}
+		if ok {
+			q.Resources <- r
+		}
+	}
+}
+
+// finish marks r, taken off the queue, as fully processed
+func (q *Queue) finish(r Resource) {
+	q.frontierLock.Lock()
+	delete(q.frontier, r.URL)
+	q.frontierLock.Unlock()
+	q.Done()
+}
+
+// shelve marks r, taken off the queue, as done for this run without
+// processing it. It stays in the frontier, so a saved crawl state keeps
+// it for a later run.
+func (q *Queue) shelve(r Resource) {
+	q.Done()
+}
+
+// frontierResources returns the resources pushed but not yet finished
+func (q *Queue) frontierResources() []Resource {
+	q.frontierLock.Lock()
+	defer q.frontierLock.Unlock()
+	resources, err := q.backlog.resources()
+	if err != nil {
+		fmt.Printf("Error reading the frontier back from disk: %v\n", err)
+	}
+	for _, r := range q.frontier {
+		resources = append(resources, r)
+	}
+	return resources
+}
+
+// waiting returns the number of resources pushed but not yet taken off
+// the queue
+func (q *Queue) waiting() int {
+	q.frontierLock.Lock()
+	defer q.frontierLock.Unlock()
+	return q.backlog.len() + len(q.Resources)
+}
+
+// hold counts work that may push resources, like reading sitemaps, as
+// pending until Done is called for it, so the queue stays open meanwhile
+func (q *Queue) hold() {
+	q.frontierLock.Lock()
+	q.pending++
+	q.frontierLock.Unlock()
+}
+
+// Done marks one pushed resource as fully processed, including queuing
+// whatever it links to
+func (q *Queue) Done() {
+	q.frontierLock.Lock()
+	defer q.frontierLock.Unlock()
+	if q.pending--; q.pending < 0 {
+		panic("mirror: Queue.Done called more times than resources were pushed")
+	}
+	if q.drained() {
+		q.frontierCond.Broadcast()
+	}
+}
+
+// drained reports whether Resources is to be closed. The caller holds
+// frontierLock.
+func (q *Queue) drained() bool {
+	return q.draining && q.pending == 0
+}
+
+// closeWhenDrained has Resources closed once nothing is pending, so
+// workers ranging over it stop. It is called once the seed resources are
+// pushed, or held for, and doesn't wait.
+func (q *Queue) closeWhenDrained() {
+	q.feeding.Do(func() { go q.feed() })
+	q.frontierLock.Lock()
+	q.draining = true
+	q.frontierCond.Broadcast()
+	q.frontierLock.Unlock()
+}
+
+// pushLater puts a resource taken off the queue back on it after d. It
+// stays pending in the meantime.
+func (q *Queue) pushLater(r Resource, d time.Duration) {
+	time.AfterFunc(d, func() {
+		q.frontierLock.Lock()
+		delete(q.frontier, r.URL)
+		q.backlog.push(r)
+		q.frontierCond.Signal()
+		q.frontierLock.Unlock()
+	})
+}
@@ -0,0 +1,51 @@
+package mirror
+
+import (
+	"errors"
+	"net/url"
+)
+
+// errRedirectedToFetched marks a resource redirecting to a URL that is
+// downloaded on its own, so its body was not saved again
+var errRedirectedToFetched = errors.New("redirects to a URL fetched separately")
+
+// errRedirectedOutOfScope marks a resource redirecting to a URL the
+// crawl's filters rule out, so its body was neither saved nor parsed
+var errRedirectedOutOfScope = errors.New("redirects outside the crawl")
+
+// redirectedAway reports whether err is one of the errors redirected
+// returns for a body that is not to be kept
+func redirectedAway(err error) bool {
+	return errors.Is(err, errRedirectedToFetched) || errors.Is(err, errRedirectedOutOfScope)
+}
+
+// redirected records that resource redirected to final, so that both
+// count as processed and links to either find the one saved copy. It
+// returns errRedirectedOutOfScope if final is ruled out by the filters
+// a link to it would be, and errRedirectedToFetched if final was queued
+// already, leaving the body to that download; otherwise resource is
+// saved where final would be. The seed and listed pages were asked for
+// by name, so where they redirect is not checked.
+func (m *Mirror) redirected(resource *Resource, final *url.URL) error {
+	resource.RedirectedTo = final.String()
+
+	if resource.Referrer != "" {
+		if reason, _ := m.parser.scope(final, resource.Requisite); reason != "" {
+			m.parser.skip(reason)
+			return errRedirectedOutOfScope
+		}
+	}
+
+	m.queue.ProcessLock.Lock()
+	defer m.queue.ProcessLock.Unlock()
+	m.queue.Redirects[resource.URL] = resource.RedirectedTo
+	if !m.queue.Processed.Add(resource.RedirectedTo) {
+		return errRedirectedToFetched
+	}
+
+	// Listing and spidering only fetch pages to a scratch file
+	if !m.config.ListURLs && !m.config.Spider {
+		resource.LocalPath = m.parser.localPath(final)
+	}
+	return nil
+}
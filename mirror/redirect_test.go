@@ -0,0 +1,65 @@
+package mirror
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRedirectScope(t *testing.T) {
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<a href="/elsewhere">elsewhere</a>`)
+	}))
+	defer other.Close()
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprint(w, `<a href="/away">away</a> <a href="/hidden">hidden</a> <a href="/moved">moved</a>`)
+		case "/away":
+			http.Redirect(w, r, other.URL+"/page", http.StatusFound)
+		case "/hidden":
+			http.Redirect(w, r, "/private/page", http.StatusFound)
+		case "/moved":
+			http.Redirect(w, r, "/public/page", http.StatusFound)
+		default:
+			fmt.Fprint(w, `<p>page</p>`)
+		}
+	}))
+	defer site.Close()
+
+	dir := t.TempDir()
+	m, err := New(&Config{URL: site.URL + "/", OutputDir: dir, IgnoreRobots: true, ExcludePaths: []string{"/private"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	host := filepath.Join(dir, strings.TrimPrefix(site.URL, "http://"))
+	tests := []struct {
+		path  string
+		saved bool
+	}{
+		{filepath.Join(host, "public", "page"), true},
+		{filepath.Join(host, "private", "page"), false},
+		{filepath.Join(host, "away"), false},
+		{filepath.Join(host, "hidden"), false},
+	}
+	for _, tt := range tests {
+		_, err := os.Stat(tt.path)
+		if saved := err == nil; saved != tt.saved {
+			t.Errorf("%s saved = %v, want %v", tt.path, saved, tt.saved)
+		}
+	}
+	if m.queue.Processed.Has(other.URL + "/elsewhere") {
+		t.Error("the links of a page redirected to another host are followed")
+	}
+	if got := m.parser.skipped["host"]; got != 1 {
+		t.Errorf("%d redirects skipped for their host, want 1", got)
+	}
+}
@@ -0,0 +1,81 @@
+package mirror
+
+import (
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// scriptLocationRe matches the simple ways inline scripts send the browser
+// elsewhere: assigning location or location.href, or calling
+// location.replace or location.assign, with a string literal
+var scriptLocationRe = regexp.MustCompile(`\blocation(?:\.href)?\s*=\s*["']([^"']+)["']|\blocation\.(?:replace|assign)\(\s*["']([^"']+)["']\s*\)`)
+
+// clientRedirects returns the URLs element n sends the browser to on its
+// own: the target of a <meta http-equiv="refresh">, or those an inline
+// script assigns to window.location
+func clientRedirects(n *html.Node) []string {
+	switch n.Data {
+	case "meta":
+		if !strings.EqualFold(attrValue(n, "http-equiv"), "refresh") {
+			return nil
+		}
+		if _, target := refreshTarget(attrValue(n, "content")); target != "" {
+			return []string{target}
+		}
+	case "script":
+		if _, ok := attr(n, "src"); ok {
+			return nil
+		}
+		var targets []string
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != html.TextNode {
+				continue
+			}
+			for _, m := range scriptLocationRe.FindAllStringSubmatch(c.Data, -1) {
+				targets = append(targets, m[1]+m[2])
+			}
+		}
+		return targets
+	}
+	return nil
+}
+
+// refreshTarget splits the content of a refresh meta tag, such as
+// "0; url='next.html'", into what comes before the URL and the URL. The
+// URL is "" if the page only reloads itself.
+func refreshTarget(content string) (prefix, target string) {
+	i := 0
+	for i < len(content) && (content[i] == ' ' || content[i] == '\t' || content[i] >= '0' && content[i] <= '9' || content[i] == '.') {
+		i++
+	}
+	if i < len(content) && (content[i] == ';' || content[i] == ',') {
+		i++
+	}
+	for i < len(content) && (content[i] == ' ' || content[i] == '\t') {
+		i++
+	}
+	if rest := content[i:]; len(rest) >= 3 && strings.EqualFold(rest[:3], "url") {
+		j := 3
+		for j < len(rest) && (rest[j] == ' ' || rest[j] == '\t') {
+			j++
+		}
+		if j < len(rest) && rest[j] == '=' {
+			i += j + 1
+			for i < len(content) && (content[i] == ' ' || content[i] == '\t') {
+				i++
+			}
+		}
+	}
+
+	target = strings.TrimSpace(content[i:])
+	if len(target) > 0 && (target[0] == '"' || target[0] == '\'') {
+		if end := strings.IndexByte(target[1:], target[0]); end >= 0 {
+			target = target[1 : end+1]
+		} else {
+			target = target[1:]
+		}
+	}
+	return content[:i], target
+}
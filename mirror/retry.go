@@ -0,0 +1,116 @@
+package mirror
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"time"
+)
+
+// RetryStrategy decides whether a failed attempt is retried and how long
+// to wait first. statusCode is 0 when the attempt failed without an HTTP
+// response. Embedders can supply their own implementation.
+type RetryStrategy interface {
+	Retry(attempt int, statusCode int, err error) (delay time.Duration, retry bool)
+	Timeout() time.Duration // deadline for each attempt; 0 for none
+}
+
+// RetryPolicy is the standard exponential-backoff RetryStrategy
+type RetryPolicy struct {
+	Tries          int           // Total attempts, including the first
+	RetryStatus    []int         // Status codes to retry; 408, 429 and 5xx if empty
+	NoNetworkRetry bool          // Don't retry failures that got no response
+	InitialBackoff time.Duration // Delay before the second attempt
+	MaxBackoff     time.Duration // Upper bound on the delay; unbounded if 0
+	Multiplier     float64       // Growth factor between delays; 2 if 0
+	AttemptTimeout time.Duration // Deadline for each attempt; none if 0
+}
+
+// DefaultRetryPolicy returns a policy making tries attempts with delays
+// of 1s, 2s, 4s... capped at 10s
+func DefaultRetryPolicy(tries int) *RetryPolicy {
+	return &RetryPolicy{
+		Tries:          tries,
+		InitialBackoff: time.Second,
+		MaxBackoff:     10 * time.Second,
+		Multiplier:     2,
+	}
+}
+
+// Retry implements RetryStrategy
+func (p *RetryPolicy) Retry(attempt int, statusCode int, err error) (time.Duration, bool) {
+	if attempt >= p.Tries || !p.retryable(statusCode, err) {
+		return 0, false
+	}
+
+	multiplier := p.Multiplier
+	if multiplier <= 0 {
+		multiplier = 2
+	}
+	delay := float64(p.InitialBackoff)
+	for i := 1; i < attempt; i++ {
+		delay *= multiplier
+		if p.MaxBackoff > 0 && delay >= float64(p.MaxBackoff) {
+			return p.MaxBackoff, true
+		}
+	}
+	return time.Duration(delay), true
+}
+
+// Timeout implements RetryStrategy
+func (p *RetryPolicy) Timeout() time.Duration {
+	return p.AttemptTimeout
+}
+
+func (p *RetryPolicy) retryable(statusCode int, err error) bool {
+	if statusCode == 0 {
+		var netErr net.Error
+		if p.NoNetworkRetry && errors.As(err, &netErr) {
+			return false
+		}
+		return true
+	}
+	if len(p.RetryStatus) == 0 {
+		return statusCode == 408 || statusCode == 429 || statusCode >= 500
+	}
+	for _, code := range p.RetryStatus {
+		if code == statusCode {
+			return true
+		}
+	}
+	return false
+}
+
+// isTransient reports whether err, which a resource finally failed
+// with, may not recur on a later run: a failure without a response, or
+// a status servers use for temporary trouble. A 404 or any other client
+// error is permanent.
+func isTransient(err error) bool {
+	var se *statusError
+	if errors.As(err, &se) {
+		return se.code == 408 || se.code == 429 || se.code >= 500
+	}
+	return !errors.Is(err, errSoft404)
+}
+
+// RetryRules picks the RetryStrategy for a host: the most specific entry
+// of PerHost whose domain matches, or Default
+type RetryRules struct {
+	Default RetryStrategy
+	PerHost map[string]RetryStrategy // keyed by domain; also matches subdomains
+}
+
+// For returns the strategy that applies to host
+func (r *RetryRules) For(host string) RetryStrategy {
+	host = strings.ToLower(host)
+	for {
+		if s, ok := r.PerHost[host]; ok {
+			return s
+		}
+		dot := strings.IndexByte(host, '.')
+		if dot < 0 {
+			return r.Default
+		}
+		host = host[dot+1:]
+	}
+}
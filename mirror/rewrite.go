@@ -0,0 +1,30 @@
+package mirror
+
+import (
+	"net/url"
+	"regexp"
+)
+
+// Rewrite replaces the matches of Pattern in discovered URLs with
+// Replacement, which may refer to submatches as $1 or ${name}
+type Rewrite struct {
+	Pattern     *regexp.Regexp
+	Replacement string
+}
+
+// rewriteURL applies rules to u in order. It returns nil if the result
+// is not an absolute URL.
+func rewriteURL(rules []Rewrite, u *url.URL) *url.URL {
+	if len(rules) == 0 {
+		return u
+	}
+	s := u.String()
+	for _, rule := range rules {
+		s = rule.Pattern.ReplaceAllString(s, rule.Replacement)
+	}
+	rewritten, err := url.Parse(s)
+	if err != nil || !rewritten.IsAbs() {
+		return nil
+	}
+	return rewritten
+}
@@ -0,0 +1,222 @@
+package mirror
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// robotsRule is one Allow or Disallow line of a robots.txt group
+type robotsRule struct {
+	pattern string
+	allow   bool
+}
+
+// robotsGroup is the set of rules for one or more user agents
+type robotsGroup struct {
+	agents []string
+	rules  []robotsRule
+	delay  time.Duration // Crawl-delay
+}
+
+// robotsCache fetches each host's robots.txt once and answers whether
+// paths on it may be crawled
+type robotsCache struct {
+	config    *Config
+	client    *http.Client
+	userAgent string
+	mu        sync.Mutex
+	hosts     map[string]*robotsEntry
+}
+
+// robotsEntry is the robots.txt group of one host, once ready is closed
+type robotsEntry struct {
+	ready chan struct{}
+	group robotsGroup
+}
+
+func newRobotsCache(config *Config, client *http.Client, userAgent string) *robotsCache {
+	return &robotsCache{config: config, client: client, userAgent: userAgent, hosts: map[string]*robotsEntry{}}
+}
+
+// group returns the robots.txt group that applies to us on u's host. The
+// first caller for a host fetches robots.txt, without holding the lock so
+// a slow host only holds up its own URLs, and later callers wait for it.
+func (c *robotsCache) group(u *url.URL) robotsGroup {
+	key := u.Scheme + "://" + u.Host
+	c.mu.Lock()
+	e, ok := c.hosts[key]
+	if !ok {
+		e = &robotsEntry{ready: make(chan struct{})}
+		c.hosts[key] = e
+	}
+	c.mu.Unlock()
+
+	if !ok {
+		e.group = c.fetch(key)
+		close(e.ready)
+	}
+	<-e.ready
+	return e.group
+}
+
+// crawlDelay returns the Crawl-delay robots.txt asks of us on u's host
+func (c *robotsCache) crawlDelay(u *url.URL) time.Duration {
+	return c.group(u).delay
+}
+
+// allowed reports whether robots.txt on u's host permits fetching u
+func (c *robotsCache) allowed(u *url.URL) bool {
+	rules := c.group(u).rules
+
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+	return robotsAllowed(rules, path)
+}
+
+// fetch returns the group that applies to us on the host at origin. A
+// missing or unreadable robots.txt allows everything.
+func (c *robotsCache) fetch(origin string) robotsGroup {
+	req, err := c.config.newRequest(context.Background(), origin+"/robots.txt")
+	if err != nil {
+		return robotsGroup{}
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return robotsGroup{}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return robotsGroup{}
+	}
+	return selectGroup(parseRobots(io.LimitReader(resp.Body, 512*1024)), c.userAgent)
+}
+
+// parseRobots splits a robots.txt file into groups. Consecutive
+// User-agent lines start a single group shared by those agents.
+func parseRobots(r io.Reader) []robotsGroup {
+	var groups []robotsGroup
+	var current *robotsGroup
+	inAgents := false
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		field, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		field = strings.ToLower(strings.TrimSpace(field))
+		value = strings.TrimSpace(value)
+
+		switch field {
+		case "user-agent":
+			if !inAgents {
+				groups = append(groups, robotsGroup{})
+				current = &groups[len(groups)-1]
+			}
+			current.agents = append(current.agents, strings.ToLower(value))
+			inAgents = true
+		case "allow", "disallow":
+			inAgents = false
+			if current == nil {
+				continue
+			}
+			if value == "" {
+				// An empty Disallow allows everything
+				continue
+			}
+			current.rules = append(current.rules, robotsRule{pattern: value, allow: field == "allow"})
+		case "crawl-delay":
+			inAgents = false
+			if current == nil {
+				continue
+			}
+			if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds > 0 {
+				current.delay = time.Duration(seconds * float64(time.Second))
+			}
+		default:
+			inAgents = false
+		}
+	}
+	return groups
+}
+
+// selectGroup returns the group with the longest agent name contained
+// in userAgent, falling back to the "*" group
+func selectGroup(groups []robotsGroup, userAgent string) robotsGroup {
+	// Only the product token takes part: "Wget/1.21 (linux)" is "wget"
+	product := strings.ToLower(userAgent)
+	if i := strings.IndexAny(product, "/ "); i >= 0 {
+		product = product[:i]
+	}
+
+	var best robotsGroup
+	bestLen := -1
+	for _, g := range groups {
+		for _, agent := range g.agents {
+			switch {
+			case agent == "*":
+				if bestLen < 0 {
+					best, bestLen = g, 0
+				}
+			case product != "" && strings.Contains(product, agent) && len(agent) > bestLen:
+				best, bestLen = g, len(agent)
+			}
+		}
+	}
+	return best
+}
+
+// robotsAllowed applies rules to path: the longest matching pattern
+// decides, and Allow wins a tie
+func robotsAllowed(rules []robotsRule, path string) bool {
+	allowed, bestLen := true, -1
+	for _, rule := range rules {
+		if !robotsMatch(rule.pattern, path) {
+			continue
+		}
+		if len(rule.pattern) > bestLen || (len(rule.pattern) == bestLen && rule.allow) {
+			allowed, bestLen = rule.allow, len(rule.pattern)
+		}
+	}
+	return allowed
+}
+
+// robotsMatch matches path against a robots.txt pattern, where '*'
+// matches any run of characters and a trailing '$' anchors the end
+func robotsMatch(pattern, path string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+	rest := path[len(parts[0]):]
+	for i, part := range parts[1:] {
+		if i == len(parts)-2 && anchored {
+			return strings.HasSuffix(rest, part)
+		}
+		j := strings.Index(rest, part)
+		if j < 0 {
+			return false
+		}
+		rest = rest[j+len(part):]
+	}
+	return !anchored || rest == ""
+}
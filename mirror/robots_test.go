@@ -0,0 +1,111 @@
+package mirror
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRobotsMatch(t *testing.T) {
+	tests := []struct {
+		pattern, path string
+		want          bool
+	}{
+		{"/", "/anything", true},
+		{"/private", "/private/page", true},
+		{"/private", "/public", false},
+		{"/*.pdf", "/docs/a.pdf", true},
+		{"/*.pdf", "/docs/a.pdf?x=1", true},
+		{"/*.pdf$", "/docs/a.pdf?x=1", false},
+		{"/*.pdf$", "/docs/a.pdf", true},
+		{"/a*b*c", "/a-b-c", true},
+		{"/a*b*c", "/a-c-b", false},
+		{"/exact$", "/exact", true},
+		{"/exact$", "/exactly", false},
+	}
+	for _, tt := range tests {
+		if got := robotsMatch(tt.pattern, tt.path); got != tt.want {
+			t.Errorf("robotsMatch(%q, %q) = %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestRobotsAllowed(t *testing.T) {
+	rules := []robotsRule{
+		{pattern: "/docs", allow: false},
+		{pattern: "/docs/public", allow: true},
+		{pattern: "/tie", allow: false},
+		{pattern: "/tie", allow: true},
+	}
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/", true},
+		{"/docs/secret", false},
+		{"/docs/public/a", true},
+		{"/tie", true},
+	}
+	for _, tt := range tests {
+		if got := robotsAllowed(rules, tt.path); got != tt.want {
+			t.Errorf("robotsAllowed(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+const testRobots = `# comment
+User-agent: *
+Disallow: /all
+
+User-agent: Wget
+User-agent: other
+Disallow: /wget # trailing comment
+Allow: /wget/ok
+Crawl-delay: 1.5
+
+User-agent: WgetBot
+Disallow:
+`
+
+func TestParseRobots(t *testing.T) {
+	groups := parseRobots(strings.NewReader(testRobots))
+	if len(groups) != 3 {
+		t.Fatalf("%d groups, want 3", len(groups))
+	}
+	g := groups[1]
+	if strings.Join(g.agents, ",") != "wget,other" {
+		t.Errorf("agents = %q, want wget and other", g.agents)
+	}
+	if len(g.rules) != 2 || g.rules[0] != (robotsRule{pattern: "/wget"}) || g.rules[1] != (robotsRule{pattern: "/wget/ok", allow: true}) {
+		t.Errorf("rules = %+v", g.rules)
+	}
+	if g.delay != 1500*time.Millisecond {
+		t.Errorf("delay = %v, want 1.5s", g.delay)
+	}
+	if len(groups[2].rules) != 0 {
+		t.Errorf("an empty Disallow gives rules %+v", groups[2].rules)
+	}
+}
+
+func TestSelectGroup(t *testing.T) {
+	groups := parseRobots(strings.NewReader(testRobots))
+	tests := []struct {
+		userAgent string
+		want      string // First agent of the group chosen
+	}{
+		{"Wget/1.21 (linux)", "wget"},
+		{"wgetbot/2.0", "wgetbot"},
+		{"curl/8.0", "*"},
+		{"", "*"},
+	}
+	for _, tt := range tests {
+		g := selectGroup(groups, tt.userAgent)
+		if len(g.agents) == 0 || g.agents[0] != tt.want {
+			t.Errorf("selectGroup(%q) = %q, want the group of %q", tt.userAgent, g.agents, tt.want)
+		}
+	}
+
+	if g := selectGroup(groups[1:2], "curl/8.0"); len(g.agents) != 0 {
+		t.Errorf("selectGroup with no match and no * group = %q, want none", g.agents)
+	}
+}
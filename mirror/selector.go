@@ -0,0 +1,252 @@
+package mirror
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// selector is a parsed group of CSS selectors, matching an element if
+// any of them does. Type, universal, .class, #id and [attr] selectors
+// (with =, ~=, ^=, $= and *=) are supported, joined by descendant and
+// child (>) combinators.
+type selector [][]compound
+
+// compound is one step of a selector, such as div.main or a[href]
+type compound struct {
+	combinator byte // How it relates to the step before: ' ' or '>'; 0 for the first
+	tag        string
+	id         string
+	classes    []string
+	attrs      []attrMatch
+}
+
+type attrMatch struct {
+	name, op, value string // op is "" when only the attribute's presence counts
+}
+
+// parseSelector parses a selector group such as "main a, .pagination a"
+func parseSelector(s string) (selector, error) {
+	var group selector
+	for _, part := range strings.Split(s, ",") {
+		chain, err := parseChain(part)
+		if err != nil {
+			return nil, fmt.Errorf("selector %q: %v", s, err)
+		}
+		group = append(group, chain)
+	}
+	return group, nil
+}
+
+// parseChain parses one selector of a group
+func parseChain(s string) ([]compound, error) {
+	var chain []compound
+	var current *compound
+	combinator := byte(0)
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			if current != nil && combinator == 0 {
+				combinator = ' '
+			}
+			current = nil
+			i++
+			continue
+		case c == '>':
+			if len(chain) == 0 {
+				return nil, fmt.Errorf("nothing before '>'")
+			}
+			combinator = '>'
+			current = nil
+			i++
+			continue
+		}
+
+		if current == nil {
+			chain = append(chain, compound{combinator: combinator})
+			current = &chain[len(chain)-1]
+			combinator = 0
+		}
+		switch {
+		case c == '*':
+			i++
+		case c == '.' || c == '#':
+			name, n := selectorIdent(s[i+1:])
+			if name == "" {
+				return nil, fmt.Errorf("expected a name after %q", c)
+			}
+			if c == '.' {
+				current.classes = append(current.classes, name)
+			} else {
+				current.id = name
+			}
+			i += 1 + n
+		case c == '[':
+			end := strings.IndexByte(s[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unclosed '['")
+			}
+			attr, err := parseAttrMatch(s[i+1 : i+end])
+			if err != nil {
+				return nil, err
+			}
+			current.attrs = append(current.attrs, attr)
+			i += end + 1
+		default:
+			name, n := selectorIdent(s[i:])
+			if name == "" {
+				return nil, fmt.Errorf("unexpected %q", c)
+			}
+			current.tag = strings.ToLower(name)
+			i += n
+		}
+	}
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("empty selector")
+	}
+	if combinator == '>' {
+		return nil, fmt.Errorf("nothing after '>'")
+	}
+	return chain, nil
+}
+
+// parseAttrMatch parses what is inside [ ]
+func parseAttrMatch(s string) (attrMatch, error) {
+	s = strings.TrimSpace(s)
+	eq := strings.IndexByte(s, '=')
+	if eq < 0 {
+		if name, n := selectorIdent(s); name != "" && n == len(s) {
+			return attrMatch{name: strings.ToLower(name)}, nil
+		}
+		return attrMatch{}, fmt.Errorf("bad attribute selector [%s]", s)
+	}
+
+	op := "="
+	nameEnd := eq
+	if eq > 0 && strings.IndexByte("~^$*", s[eq-1]) >= 0 {
+		op = s[eq-1 : eq+1]
+		nameEnd = eq - 1
+	}
+	name := strings.ToLower(strings.TrimSpace(s[:nameEnd]))
+	value := strings.TrimSpace(s[eq+1:])
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		value = value[1 : len(value)-1]
+	}
+	if ident, n := selectorIdent(name); ident == "" || n != len(name) {
+		return attrMatch{}, fmt.Errorf("bad attribute selector [%s]", s)
+	}
+	return attrMatch{name: name, op: op, value: value}, nil
+}
+
+// selectorIdent returns the name at the start of s and its length
+func selectorIdent(s string) (string, int) {
+	n := 0
+	for n < len(s) {
+		c := s[n]
+		if c == '-' || c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80 {
+			n++
+			continue
+		}
+		break
+	}
+	return s[:n], n
+}
+
+// match reports whether element n matches any selector of the group
+func (s selector) match(n *html.Node) bool {
+	for _, chain := range s {
+		if matchChain(chain, n) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchChain reports whether n matches the last step of chain, with its
+// ancestors matching the steps before
+func matchChain(chain []compound, n *html.Node) bool {
+	last := chain[len(chain)-1]
+	if !last.matches(n) {
+		return false
+	}
+	if len(chain) == 1 {
+		return true
+	}
+	rest := chain[:len(chain)-1]
+	for a := n.Parent; a != nil && a.Type == html.ElementNode; a = a.Parent {
+		if matchChain(rest, a) {
+			return true
+		}
+		if last.combinator == '>' {
+			return false
+		}
+	}
+	return false
+}
+
+// matches reports whether element n matches the step on its own
+func (c compound) matches(n *html.Node) bool {
+	if n.Type != html.ElementNode || c.tag != "" && n.Data != c.tag {
+		return false
+	}
+	if c.id != "" && attrValue(n, "id") != c.id {
+		return false
+	}
+	if len(c.classes) > 0 {
+		classes := strings.Fields(attrValue(n, "class"))
+		for _, want := range c.classes {
+			if !containsString(classes, want) {
+				return false
+			}
+		}
+	}
+	for _, a := range c.attrs {
+		value, ok := attr(n, a.name)
+		if !ok {
+			return false
+		}
+		switch a.op {
+		case "=":
+			ok = value == a.value
+		case "~=":
+			ok = containsString(strings.Fields(value), a.value)
+		case "^=":
+			ok = a.value != "" && strings.HasPrefix(value, a.value)
+		case "$=":
+			ok = a.value != "" && strings.HasSuffix(value, a.value)
+		case "*=":
+			ok = a.value != "" && strings.Contains(value, a.value)
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// attr returns the value of n's attribute name and whether it has one
+func attr(n *html.Node, name string) (string, bool) {
+	for _, a := range n.Attr {
+		if a.Namespace == "" && a.Key == name {
+			return a.Val, true
+		}
+	}
+	return "", false
+}
+
+// attrValue returns the value of n's attribute name, or ""
+func attrValue(n *html.Node, name string) string {
+	value, _ := attr(n, name)
+	return value
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
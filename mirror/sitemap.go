@@ -0,0 +1,115 @@
+package mirror
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// maxSitemaps bounds how many sitemap files one run will read, since
+// sitemap indexes can nest and point at each other
+const maxSitemaps = 1000
+
+// sitemapDoc covers both a <urlset> and a <sitemapindex>
+type sitemapDoc struct {
+	XMLName  xml.Name
+	URLs     []sitemapLoc `xml:"url"`
+	Sitemaps []sitemapLoc `xml:"sitemap"`
+}
+
+type sitemapLoc struct {
+	Loc string `xml:"loc"`
+}
+
+// seedFromSitemaps queues every page listed in the sitemaps of the base
+// URL's site: those named in robots.txt, or /sitemap.xml if it names
+// none. The usual filters apply to each page as if it were linked from
+// the seed.
+func (m *Mirror) seedFromSitemaps() {
+	base := m.parser.baseURL
+	origin := &url.URL{Scheme: base.Scheme, Host: base.Host}
+
+	pending := robotsSitemaps(m.downloader, origin.String()+"/robots.txt")
+	if len(pending) == 0 {
+		pending = []string{origin.String() + "/sitemap.xml"}
+	}
+
+	seen := map[string]bool{}
+	for len(pending) > 0 && len(seen) < maxSitemaps {
+		sitemapURL := pending[0]
+		pending = pending[1:]
+		if seen[sitemapURL] {
+			continue
+		}
+		seen[sitemapURL] = true
+
+		doc, err := fetchSitemap(m.downloader, sitemapURL)
+		if err != nil {
+			fmt.Printf("Error reading sitemap %s: %v\n", sitemapURL, err)
+			continue
+		}
+		for _, s := range doc.Sitemaps {
+			pending = append(pending, strings.TrimSpace(s.Loc))
+		}
+		for _, u := range doc.URLs {
+			m.parser.processURL(strings.TrimSpace(u.Loc), base, sitemapURL, 1, false)
+		}
+	}
+}
+
+// robotsSitemaps returns the Sitemap: entries of the robots.txt at
+// robotsURL
+func robotsSitemaps(d *Downloader, robotsURL string) []string {
+	resp, err := d.get(robotsURL)
+	if err != nil {
+		return nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil
+	}
+
+	var sitemaps []string
+	scanner := bufio.NewScanner(io.LimitReader(resp.Body, 512*1024))
+	for scanner.Scan() {
+		field, value, ok := strings.Cut(scanner.Text(), ":")
+		if ok && strings.EqualFold(strings.TrimSpace(field), "sitemap") {
+			sitemaps = append(sitemaps, strings.TrimSpace(value))
+		}
+	}
+	return sitemaps
+}
+
+// fetchSitemap downloads and decodes a sitemap or sitemap index,
+// gunzipping .xml.gz files
+func fetchSitemap(d *Downloader, sitemapURL string) (*sitemapDoc, error) {
+	resp, err := d.get(sitemapURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &statusError{code: resp.StatusCode}
+	}
+
+	var body io.Reader = resp.Body
+	if strings.HasSuffix(sitemapURL, ".gz") {
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		body = gz
+	}
+
+	var doc sitemapDoc
+	if err := xml.NewDecoder(body).Decode(&doc); err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
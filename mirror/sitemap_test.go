@@ -0,0 +1,36 @@
+package mirror
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSitemapOnAnotherHost(t *testing.T) {
+	var site, cdn *httptest.Server
+	site = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			fmt.Fprintf(w, "Sitemap: %s/sitemap.xml\n", cdn.URL)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer site.Close()
+	cdn = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<urlset><url><loc>%s/page</loc></url><url><loc>%s/asset</loc></url></urlset>`, site.URL, cdn.URL)
+	}))
+	defer cdn.Close()
+
+	m, err := New(&Config{URL: site.URL + "/", OutputDir: t.TempDir(), IgnoreRobots: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.seedFromSitemaps()
+	if !m.queue.Processed.Has(site.URL + "/page") {
+		t.Error("a page of the site listed in a sitemap on another host is not queued")
+	}
+	if m.queue.Processed.Has(cdn.URL + "/asset") {
+		t.Error("a page on the sitemap's own host is queued")
+	}
+}
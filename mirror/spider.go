@@ -0,0 +1,161 @@
+package mirror
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// spider checks that resource is there without saving it. Pages and
+// stylesheets are fetched to a scratch file to find their links; anything
+// else only gets a HEAD request. It reports whether resource was queued
+// again to be retried.
+func (m *Mirror) spider(resource Resource) (requeued bool) {
+	original := resource
+	start := time.Now()
+	var err error
+	if m.hasLinks(resource) {
+		err = m.scan(&resource)
+	} else {
+		err = m.retry(&resource, m.downloader.check)
+	}
+	latency := time.Since(start)
+	if m.retryLater(original, err) {
+		return true
+	}
+
+	var tooLarge *tooLargeError
+	switch {
+	case redirectedAway(err):
+		m.status.set(resource.URL, "redirected to "+resource.RedirectedTo)
+	case errors.As(err, &tooLarge):
+		// It is there, just not parsed
+		m.status.set(resource.URL, err.Error())
+		m.counts.count(resource, nil)
+	case err != nil:
+		fmt.Printf("Broken link %s: %v\n", resource.URL, err)
+		m.status.set(resource.URL, err.Error())
+		m.counts.count(resource, err)
+		m.recordFailure(resource.URL, err)
+	default:
+		m.counts.count(resource, nil)
+		m.recordLatency(resource.URL, latency)
+	}
+	return
+}
+
+// slowResponse is a URL that took longer than SlowThreshold to check
+type slowResponse struct {
+	url     string
+	latency time.Duration
+}
+
+// recordLatency notes url if checking it took longer than SlowThreshold
+func (m *Mirror) recordLatency(url string, latency time.Duration) {
+	if m.config.SlowThreshold <= 0 || latency <= m.config.SlowThreshold {
+		return
+	}
+	m.slowLock.Lock()
+	defer m.slowLock.Unlock()
+	m.slow = append(m.slow, slowResponse{url: url, latency: latency})
+}
+
+// slowResponses returns the responses slower than SlowThreshold, slowest
+// first
+func (m *Mirror) slowResponses() []slowResponse {
+	m.slowLock.Lock()
+	slow := append([]slowResponse(nil), m.slow...)
+	m.slowLock.Unlock()
+	sort.Slice(slow, func(i, j int) bool {
+		return slow[i].latency > slow[j].latency
+	})
+	return slow
+}
+
+// check requests resource without saving the body: HEAD, or GET for
+// servers that don't support HEAD. It records redirects as
+// downloadResource does.
+func (d *Downloader) check(ctx context.Context, resource *Resource) error {
+	for _, method := range []string{http.MethodHead, http.MethodGet} {
+		req, err := d.config.newRequest(ctx, resource.URL)
+		if err != nil {
+			return err
+		}
+		req.Method = method
+
+		resp, err := d.client.Do(req)
+		if err != nil {
+			return err
+		}
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+		resp.Body.Close()
+
+		if method == http.MethodHead && (resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented) {
+			continue
+		}
+		if resp.StatusCode >= 400 {
+			return &statusError{
+				code:       resp.StatusCode,
+				retryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+			}
+		}
+		resource.ContentType = resp.Header.Get("Content-Type")
+		if final := normalizeURL(resp.Request.URL, d.config.SortQuery); final.String() != resource.URL && d.redirected != nil {
+			if err := d.redirected(resource, final); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return nil
+}
+
+// spiderReport prints the dead links found, with the pages linking to
+// them, the redirects followed and, with SlowThreshold, the slow
+// responses. It returns an error if any link is
+// dead.
+func (m *Mirror) spiderReport() error {
+	dead := m.brokenLinks()
+	if len(dead) > 0 {
+		fmt.Printf("\n%d dead links:\n", len(dead))
+		for _, link := range dead {
+			fmt.Printf("  %s (%s)\n", link.URL, link.Error)
+			for _, page := range link.Referrers {
+				fmt.Printf("      linked from %s\n", page)
+			}
+		}
+	}
+
+	m.queue.ProcessLock.RLock()
+	from := make([]string, 0, len(m.queue.Redirects))
+	for u := range m.queue.Redirects {
+		from = append(from, u)
+	}
+	sort.Strings(from)
+	if len(from) > 0 {
+		fmt.Printf("\n%d redirects:\n", len(from))
+		for _, u := range from {
+			fmt.Printf("  %s -> %s\n", u, m.queue.Redirects[u])
+			for _, page := range m.queue.referrersOf(u) {
+				fmt.Printf("      linked from %s\n", page)
+			}
+		}
+	}
+	m.queue.ProcessLock.RUnlock()
+
+	if slow := m.slowResponses(); len(slow) > 0 {
+		fmt.Printf("\nFound %d responses slower than %v:\n", len(slow), m.config.SlowThreshold)
+		for _, r := range slow {
+			fmt.Printf("  %8v  %s\n", r.latency.Round(time.Millisecond), r.url)
+		}
+	}
+
+	if len(dead) > 0 {
+		return fmt.Errorf("%d dead links found", len(dead))
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package mirror
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSpiderSlowResponses(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprint(w, `<a href="/slow.html">slow</a> <a href="/slower.pdf">slower</a> <a href="/fast.html">fast</a> <a href="/gone">gone</a>`)
+		case "/slow.html":
+			time.Sleep(150 * time.Millisecond)
+		case "/slower.pdf":
+			time.Sleep(300 * time.Millisecond)
+		case "/gone":
+			time.Sleep(150 * time.Millisecond)
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	m, err := New(&Config{URL: srv.URL + "/", OutputDir: t.TempDir(), IgnoreRobots: true, Spider: true, SlowThreshold: 100 * time.Millisecond, Workers: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Start(); err == nil {
+		t.Error("Start reports no dead links")
+	}
+
+	var got []string
+	for _, r := range m.slowResponses() {
+		got = append(got, r.url)
+	}
+	want := fmt.Sprint([]string{srv.URL + "/slower.pdf", srv.URL + "/slow.html"})
+	if fmt.Sprint(got) != want {
+		t.Errorf("slow responses = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,200 @@
+package mirror
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// stateInterval is how often the crawl state is saved while mirroring
+const stateInterval = 10 * time.Second
+
+// crawlState is what is saved to StateFile so an interrupted mirror can
+// be resumed: every URL seen, the resources not yet done, how each
+// finished resource went, where each was saved, where each redirect led
+// and which still need their links converted. A finished resource with
+// no status went fine. With VisitedStore, the URLs seen stay in the store
+// and the state only records how many of them it covers.
+type crawlState struct {
+	URL           string            `json:"url"`
+	Visited       []string          `json:"visited"`
+	VisitedStore  string            `json:"visited_store,omitempty"`
+	VisitedStored int64             `json:"visited_stored,omitempty"` // URLs in the store when the state was saved
+	Frontier      []Resource        `json:"frontier"`
+	Status        map[string]string `json:"status"`
+	Saved         map[string]string `json:"saved"`
+	Redirects     map[string]string `json:"redirects"`
+	ToConvert     []Resource        `json:"to_convert"`
+}
+
+// resourceStatus records how fetching each resource went, when it didn't
+// go fine. Those that did, nearly all of them on most crawls, are left
+// out to keep it small.
+type resourceStatus struct {
+	mu     sync.Mutex
+	status map[string]string
+}
+
+func (s *resourceStatus) set(url, status string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.status == nil {
+		s.status = map[string]string{}
+	}
+	s.status[url] = status
+}
+
+func (s *resourceStatus) get(url string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status[url]
+}
+
+// defaultStateFile returns where the crawl state is kept when StateFile
+// is not set
+func defaultStateFile(outputDir string) string {
+	return filepath.Join(outputDir, ".wget-mirror-state.json")
+}
+
+// snapshot captures the current crawl state. It fails if the visited
+// store can't be synced, as a state recording URLs the store lost could
+// not be resumed from.
+func (m *Mirror) snapshot() (crawlState, error) {
+	state := crawlState{
+		URL:       m.config.URL,
+		Status:    map[string]string{},
+		Saved:     map[string]string{},
+		Redirects: map[string]string{},
+	}
+
+	m.queue.ProcessLock.RLock()
+	addVisited := func(url string) {
+		state.Visited = append(state.Visited, url)
+	}
+	var err error
+	if store, ok := m.queue.Processed.(*diskVisited); ok {
+		state.VisitedStore = m.config.VisitedStore
+		state.VisitedStored, err = store.checkpoint()
+	} else {
+		err = m.queue.Processed.Each(addVisited)
+	}
+	if err != nil {
+		m.queue.ProcessLock.RUnlock()
+		return state, fmt.Errorf("visited store: %v", err)
+	}
+	for url, saved := range m.queue.Saved {
+		state.Saved[url] = saved
+	}
+	for url, to := range m.queue.Redirects {
+		state.Redirects[url] = to
+	}
+	m.queue.ProcessLock.RUnlock()
+
+	m.toConvertLock.Lock()
+	state.ToConvert = append(state.ToConvert, m.toConvert...)
+	m.toConvertLock.Unlock()
+
+	state.Frontier = m.queue.frontierResources()
+
+	m.status.mu.Lock()
+	for url, status := range m.status.status {
+		state.Status[url] = status
+	}
+	m.status.mu.Unlock()
+	return state, nil
+}
+
+// saveState writes the crawl state to StateFile, replacing it in one
+// step so an interruption never leaves it half written
+func (m *Mirror) saveState() error {
+	state, err := m.snapshot()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(m.config.StateFile), 0755); err != nil {
+		return err
+	}
+	tmp := m.config.StateFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.config.StateFile)
+}
+
+// loadState reads a crawl state saved by an earlier run of the same
+// mirror. It returns nil if there is none.
+func (m *Mirror) loadState() (*crawlState, error) {
+	data, err := os.ReadFile(m.config.StateFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var state crawlState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("%s: %v", m.config.StateFile, err)
+	}
+	if state.URL != m.config.URL {
+		return nil, fmt.Errorf("%s is the state of a mirror of %s, not %s", m.config.StateFile, state.URL, m.config.URL)
+	}
+	if state.VisitedStore != m.config.VisitedStore && state.VisitedStored > 0 {
+		return nil, fmt.Errorf("%s is the state of a mirror keeping its visited set in %s; resume it with --visited-store %s", m.config.StateFile, state.VisitedStore, state.VisitedStore)
+	}
+	return &state, nil
+}
+
+// resume restores the crawl from state and queues its frontier
+func (m *Mirror) resume(state *crawlState) {
+	m.queue.ProcessLock.Lock()
+	for _, url := range state.Visited {
+		m.queue.Processed.Add(url)
+	}
+	for url, saved := range state.Saved {
+		m.queue.Saved[url] = saved
+	}
+	for url, to := range state.Redirects {
+		m.queue.Redirects[url] = to
+	}
+	m.queue.ProcessLock.Unlock()
+
+	m.toConvertLock.Lock()
+	m.toConvert = append(m.toConvert, state.ToConvert...)
+	m.toConvertLock.Unlock()
+
+	for url, status := range state.Status {
+		m.status.set(url, status)
+	}
+	seen := len(state.Visited)
+	if store, ok := m.queue.Processed.(*diskVisited); ok {
+		seen = store.len()
+	}
+	fmt.Printf("Resuming mirror of %s: %d URLs seen, %d left\n", state.URL, seen, len(state.Frontier))
+	for _, resource := range state.Frontier {
+		m.queue.Push(resource)
+	}
+}
+
+// saveStatePeriodically saves the crawl state every stateInterval until
+// stop is closed
+func (m *Mirror) saveStatePeriodically(stop <-chan struct{}) {
+	ticker := time.NewTicker(stateInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := m.saveState(); err != nil {
+				fmt.Printf("Error saving mirror state: %v\n", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package mirror
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// errSoft404 marks a page that was served with 200 OK but looks like an
+// error page
+var errSoft404 = errors.New("soft 404: page looks like an error page")
+
+// failure records a resource that could not be archived
+type failure struct {
+	URL string
+	Err error
+}
+
+// recordFailure notes a resource missing from the mirror
+func (m *Mirror) recordFailure(url string, err error) {
+	m.failuresLock.Lock()
+	defer m.failuresLock.Unlock()
+	m.failures = append(m.failures, failure{URL: url, Err: err})
+}
+
+// strictError returns an error describing every missing resource when
+// the mirror runs in strict archive mode
+func (m *Mirror) strictError() error {
+	if !m.config.StrictArchive {
+		return nil
+	}
+	m.failuresLock.Lock()
+	defer m.failuresLock.Unlock()
+	if len(m.failures) == 0 {
+		return nil
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "strict archive: %d resources missing or invalid", len(m.failures))
+	for _, f := range m.failures {
+		fmt.Fprintf(&b, "\n  %s: %v", f.URL, f.Err)
+	}
+	return errors.New(b.String())
+}
+
+// isSoft404 reports whether the HTML page at filePath has a title that
+// reads like an error page
+func isSoft404(filePath string) bool {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	title := strings.ToLower(pageTitle(f))
+	return strings.Contains(title, "404") ||
+		strings.Contains(title, "not found") ||
+		strings.Contains(title, "page does not exist")
+}
+
+// pageTitle returns the text of the first <title> element
+func pageTitle(r io.Reader) string {
+	z := html.NewTokenizer(r)
+	inTitle := false
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return ""
+		case html.StartTagToken:
+			name, _ := z.TagName()
+			inTitle = string(name) == "title"
+		case html.TextToken:
+			if inTitle {
+				return strings.TrimSpace(string(z.Text()))
+			}
+		case html.EndTagToken:
+			inTitle = false
+		}
+	}
+}
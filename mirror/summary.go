@@ -0,0 +1,134 @@
+package mirror
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// runCounts counts the resources a mirror run has finished
+type runCounts struct {
+	pages       atomic.Int64
+	assets      atomic.Int64
+	notModified atomic.Int64 // also counted as pages or assets
+	errors      atomic.Int64
+}
+
+// summary totals a finished mirror run
+type summary struct {
+	Pages             int64            `json:"pages"`
+	Assets            int64            `json:"assets"`
+	NotModified       int64            `json:"not_modified"`
+	Errors            int64            `json:"errors"`
+	Bytes             int64            `json:"bytes"`
+	Skipped           map[string]int64 `json:"skipped"` // Links not followed, by the filter ruling them out
+	QuotaExceeded     bool             `json:"quota_exceeded"`
+	LeftUnfetched     int64            `json:"left_unfetched"` // Resources queued but not fetched because of MaxResources
+	ElapsedSeconds    float64          `json:"elapsed_seconds"`
+	RequestsPerSecond float64          `json:"requests_per_second"`
+}
+
+// overQuota reports whether the run has saved its Quota of bytes
+func (m *Mirror) overQuota() bool {
+	return m.config.Quota > 0 && m.BytesWritten() >= m.config.Quota
+}
+
+// count records a resource that was processed
+func (c *runCounts) count(resource Resource, err error) {
+	switch {
+	case err != nil:
+		c.errors.Add(1)
+		return
+	case resource.IsHTML:
+		c.pages.Add(1)
+	default:
+		c.assets.Add(1)
+	}
+	if resource.NotModified {
+		c.notModified.Add(1)
+	}
+}
+
+// summarize totals the run that started at start
+func (m *Mirror) summarize(start time.Time) summary {
+	elapsed := time.Since(start)
+	s := summary{
+		Pages:          m.counts.pages.Load(),
+		Assets:         m.counts.assets.Load(),
+		NotModified:    m.counts.notModified.Load(),
+		Errors:         m.counts.errors.Load(),
+		Bytes:          m.BytesWritten(),
+		Skipped:        map[string]int64{},
+		QuotaExceeded:  m.overQuota(),
+		LeftUnfetched:  m.shelved.Load(),
+		ElapsedSeconds: elapsed.Seconds(),
+	}
+
+	m.parser.skippedLock.Lock()
+	for reason, n := range m.parser.skipped {
+		s.Skipped[reason] = n
+	}
+	m.parser.skippedLock.Unlock()
+
+	if elapsed > 0 {
+		s.RequestsPerSecond = float64(s.Pages+s.Assets+s.Errors) / elapsed.Seconds()
+	}
+	return s
+}
+
+// printSummary prints the totals of the run, and writes them as JSON to
+// SummaryFile if it is set
+func (m *Mirror) printSummary(start time.Time) error {
+	s := m.summarize(start)
+	fmt.Printf("Mirror summary: %d pages, %d assets (%d not modified), %d errors, %.2f MiB in %v (%.1f requests/s)\n",
+		s.Pages, s.Assets, s.NotModified, s.Errors,
+		float64(s.Bytes)/(1024*1024),
+		time.Duration(s.ElapsedSeconds*float64(time.Second)).Round(time.Millisecond),
+		s.RequestsPerSecond)
+
+	if len(s.Skipped) > 0 {
+		reasons := make([]string, 0, len(s.Skipped))
+		for reason := range s.Skipped {
+			reasons = append(reasons, reason)
+		}
+		sort.Strings(reasons)
+		parts := make([]string, len(reasons))
+		for i, reason := range reasons {
+			parts[i] = fmt.Sprintf("%d by %s", s.Skipped[reason], reason)
+		}
+		fmt.Printf("Links skipped: %s\n", strings.Join(parts, ", "))
+	}
+	// A spider reports them with the pages linking to them
+	if failures := m.brokenLinks(); len(failures) > 0 && !m.config.Spider {
+		fmt.Printf("Failed URLs:\n")
+		for _, f := range failures {
+			kind := "permanent"
+			if f.Transient {
+				kind = "transient"
+			}
+			fmt.Printf("  %s: %s (%s)\n", f.URL, f.Error, kind)
+		}
+	}
+	if s.QuotaExceeded {
+		fmt.Printf("Download quota of %.2f MiB exceeded: the mirror is incomplete\n", float64(m.config.Quota)/(1024*1024))
+	}
+	if s.LeftUnfetched > 0 {
+		fmt.Printf("Limit of %d resources reached: %d left unfetched\n", m.config.MaxResources, s.LeftUnfetched)
+		if m.config.KeepFrontier {
+			fmt.Printf("Their state is kept in %s; run again with --continue-mirror to fetch them\n", m.config.StateFile)
+		}
+	}
+
+	if m.config.SummaryFile == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(m.config.SummaryFile, append(data, '\n'), 0644)
+}
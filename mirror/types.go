@@ -1,36 +1,169 @@
 package mirror
 
-import "sync"
+import (
+	"io"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+)
 
 // Config holds the configuration for website mirroring
 type Config struct {
 	URL          string   // Base URL to mirror
 	RejectTypes  []string // File extensions to reject (-R flag)
+	AcceptTypes  []string // If set, only keep these file extensions (-A flag); pages are still crawled
 	ExcludePaths []string // Paths to exclude (-X flag)
+	IncludePaths []string // If set, only follow links under these directories, which may contain wildcards (-I flag)
 	ConvertLinks bool     // Whether to convert links for offline viewing
 	OutputDir    string   // Directory to save mirrored content
+	Workers      int      // Resources downloaded at once; 1 if 0
+	PerHost      int      // Resources downloaded from one origin at once; no limit if 0
+	MaxDepth     int      // Maximum recursion depth (-l flag); 0 for unlimited
+	NoParent     bool     // Never ascend above the directory of URL
+
+	NoHostDirectories bool // Don't save under a directory named after the host (-nH flag)
+	CutDirs           int  // Leading URL path directories left out of saved paths (--cut-dirs flag)
+	NoDirectories     bool // Save every file straight in OutputDir (-nd flag)
+	AdjustExtension   bool // Add .html or .css to HTML and CSS files saved without it (-E flag)
+	BackupConverted   bool // Save each page as <file>.orig before converting its links (-K flag)
+	ConvertFileOnly   bool // With ConvertLinks, only rewrite the file name part of links (--convert-file-only flag)
+
+	FileNames FileNameRules // Characters escaped in saved file names (--restrict-file-names flag)
+
+	Timestamping bool // Send If-Modified-Since from local copies and keep those not modified (-N flag)
+
+	NoServerTimestamps bool // Leave saved files dated when they were downloaded rather than by Last-Modified
+
+	StateFile string // Where the crawl state is saved while mirroring; in OutputDir if empty
+	Resume    bool   // Pick up the crawl saved in StateFile instead of starting from URL (--continue-mirror flag)
+
+	DeleteAfter bool // Delete each file once it has been parsed, leaving no mirror behind (--delete-after flag)
+
+	BrokenLinks string // File to write a report of failed resources and the pages linking to them to; JSON if it ends in .json
+	SummaryFile string // File to write the totals of the run to as JSON
+	ListURLs    bool   // Only print each URL that would be fetched and where it would be saved (--list-urls flag)
+	Spider      bool   // Check every link without saving anything, then report dead links and redirects (--spider flag)
+	Dedupe      string // "hardlink" or "symlink" to link files identical to one saved earlier instead of keeping copies
+	MaxFileSize int64  // Skip resources larger than this many bytes; 0 for no limit (--max-filesize flag)
+	Quota       int64  // Stop fetching new resources once this many bytes are saved; 0 for no limit (-Q flag)
+
+	SlowThreshold time.Duration // With Spider, also report responses slower than this (--slow-threshold flag)
+
+	MaxResources int  // Fetch at most this many resources per run; 0 for no limit (--max-pages flag)
+	KeepFrontier bool // When MaxResources stops the run, keep StateFile for Resume to fetch the rest
+
+	HostDelay  time.Duration // Minimum time between requests to one host
+	HostJitter time.Duration // Random extra delay, up to this much, added to HostDelay
+
+	AcceptRegex *regexp.Regexp // If set, only keep URLs matching it; pages are still crawled
+	RejectRegex *regexp.Regexp // Skip URLs matching it
+	Rewrites    []Rewrite      // Applied in order to every discovered URL before it is filtered and fetched
+	SortQuery   bool           // Treat URLs whose query parameters differ only in order as the same
+
+	PageRequisites   bool // Fetch everything pages need to render, even off-host or past MaxDepth
+	Sitemaps         bool // Also queue the pages listed in the site's XML sitemaps
+	RemoveUnaccepted bool // Delete pages outside AcceptTypes once their links are queued
+
+	FollowSelector string // If set, only follow links inside elements matching this CSS selector; requisites are still fetched
+
+	CrawlOrder   string // "html-first" to take pages before other resources, or "depth" to take them breadth-first by Depth; in the order found if empty
+	VisitedStore string // File to keep the set of URLs already seen in instead of memory, for very large crawls
+
+	LazyAttrs []string // Extra attributes holding the URLs of lazily loaded resources, like data-src; those ending in "srcset" hold srcsets
+
+	IgnoreRobots bool        // Don't honor robots.txt
+	UserAgent    string      // Sent with every request and matched against robots.txt groups; Go's default if empty
+	Header       http.Header // Extra headers sent with every request
+
+	HonorNofollow bool // Skip rel="nofollow" links, and honor nofollow and noindex in pages' robots meta tags
+
+	SpanHosts      bool     // Follow links to other hosts (-H flag)
+	Domains        []string // With SpanHosts, only span to these domains and their subdomains (-D flag)
+	ExcludeDomains []string // Never span to these domains (--exclude-domains flag)
+
+	StrictArchive bool // Fail the run if any resource is missing or a soft 404
+	TranscodeUTF8 bool // Rewrite saved HTML, CSS and JavaScript as UTF-8
+
+	PageList   []string // Pages to archive with their requisites, without following links between them
+	DiffReport string   // File to append text diffs of HTML pages changed since the last run
+
+	SaveHeaders  string // "prepend" to put each response's status line and headers before the saved body, or "sidecar" to write them to FILE.headers
+	ManifestFile string // File to write the URL, path, status, size, hash, type, depth and referrer of every resource to as JSON
+
+	WARCFile     string // File to record every request and response to in WARC format
+	WARCCompress bool   // Gzip each WARC record
+
+	Client   *http.Client              // HTTP client to use; a default client if nil
+	Retry    *RetryRules               // How failed downloads are retried; each is tried once if nil
+	Prefetch func(host string)         // Called for each new host entering the queue, e.g. to warm a DNS cache
+	WrapBody func(io.Reader) io.Reader // Wraps each response body as it is read, e.g. to limit bandwidth
+
+	AfterDownload func(Resource)        // Called after each resource is saved
+	OnFailure     func(Resource, error) // Called for each resource that could not be saved
 }
 
 // Resource represents a web resource to be downloaded
 type Resource struct {
-	URL         string
-	LocalPath   string
-	ContentType string
-	IsHTML      bool
+	URL          string
+	LocalPath    string
+	ContentType  string
+	IsHTML       bool // A page to parse for links; decided by Content-Type once downloaded
+	IsCSS        bool
+	Size         int64  // Bytes saved, once downloaded
+	Depth        int    // Links followed from the seed URL to reach this resource
+	Unaccepted   bool   // A page outside AcceptTypes, fetched only to find links
+	NotModified  bool   // With Timestamping, the local copy was up to date and kept
+	SHA256       string // The hex SHA-256 of the body downloaded
+	RedirectedTo string // Where the request was redirected to, if it was
+	Referrer     string // The page the link to this resource was first found on; "" for the seed
+	Requisite    bool   // Linked as something the referring page needs to render
+	Headers      string // With SaveHeaders "prepend", the response head still to be put before the body
+	Attempts     int    // Failed attempts so far, for a resource queued again to be retried
+}
+
+// location returns the URL resource's body came from, which the links
+// in it are relative to
+func (r Resource) location() string {
+	if r.RedirectedTo != "" {
+		return r.RedirectedTo
+	}
+	return r.URL
 }
 
 // Queue represents a download queue for resources
 type Queue struct {
 	Resources   chan Resource
-	Processed   map[string]bool
+	Processed   VisitedSet        // URLs queued or fetched, guarded by ProcessLock
+	Hosts       map[string]bool   // Hosts seen so far, guarded by ProcessLock
+	Saved       map[string]string // Where each downloaded URL was saved, while something needs it; guarded by ProcessLock
+	Redirects   map[string]string // The URL each redirected URL led to, guarded by ProcessLock
 	ProcessLock sync.RWMutex
+
+	frontierLock sync.Mutex
+	pending      int                 // Resources pushed, and seeding still under way, not yet done
+	frontierCond *sync.Cond          // Signaled on frontierLock when the backlog grows or the queue closes
+	frontier     map[string]Resource // Resources taken off the backlog but not yet finished, by URL
+	backlog      prioritized         // Resources pushed but not yet handed to Resources, guarded by frontierLock
+	draining     bool                // Seeding has started, so Resources is closed once nothing is pending
+	feeding      sync.Once
+
+	referrersLock sync.Mutex
+	referrers     map[string]map[string]struct{} // With BrokenLinks or Spider, the pages linking to each URL, up to maxReferred URLs
 }
 
 // NewQueue creates a new download queue
 func NewQueue() *Queue {
-	return &Queue{
-		Resources:   make(chan Resource, 1000),
-		Processed:   make(map[string]bool),
+	q := &Queue{
+		Resources:   make(chan Resource), // Unbuffered, so what waits stays in the backlog in crawl order
+		Processed:   memoryVisited{},
+		Hosts:       make(map[string]bool),
+		Saved:       make(map[string]string),
+		Redirects:   make(map[string]string),
+		frontier:    make(map[string]Resource),
+		referrers:   make(map[string]map[string]struct{}),
 		ProcessLock: sync.RWMutex{},
 	}
+	q.frontierCond = sync.NewCond(&q.frontierLock)
+	return q
 }
@@ -0,0 +1,232 @@
+package mirror
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// VisitedSet is the set of URLs already queued or fetched. Queue guards it
+// with ProcessLock.
+type VisitedSet interface {
+	Has(url string) bool
+	Add(url string) bool // Reports whether url was new
+	Each(fn func(url string)) error
+	Err() error // Why the set stopped recording URLs, if it did
+	Close() error
+}
+
+// memoryVisited keeps the visited set in a map, which suits all but the
+// largest crawls
+type memoryVisited map[string]bool
+
+func (s memoryVisited) Has(url string) bool {
+	return s[url]
+}
+
+func (s memoryVisited) Add(url string) bool {
+	if s[url] {
+		return false
+	}
+	s[url] = true
+	return true
+}
+
+func (s memoryVisited) Each(fn func(url string)) error {
+	for url := range s {
+		fn(url)
+	}
+	return nil
+}
+
+func (s memoryVisited) Err() error {
+	return nil
+}
+
+func (s memoryVisited) Close() error {
+	return nil
+}
+
+var (
+	visitedURLs  = []byte("urls")  // URL -> its number, in the order added
+	visitedOrder = []byte("order") // Number -> URL
+)
+
+// diskVisited keeps the visited set in a bolt database, so memory use
+// doesn't grow with the crawl. URLs are numbered as they are added, and
+// the crawl state records how many it has seen rather than the URLs; a
+// resumed crawl drops those added after its state was saved. Writes are
+// only synced at checkpoints. Once the database fails, the set reports
+// every URL as seen so nothing more is queued, and Err says why.
+type diskVisited struct {
+	db   *bolt.DB
+	path string
+
+	mu  sync.Mutex
+	err error
+}
+
+// openDiskVisited opens the visited set in the database at path, keeping
+// the first keep URLs a previous run added there. With keep 0 the set
+// starts empty.
+func openDiskVisited(path string, keep int64) (*diskVisited, error) {
+	if keep == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: time.Second, NoSync: true})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		urls, err := tx.CreateBucketIfNotExists(visitedURLs)
+		if err != nil {
+			return err
+		}
+		order, err := tx.CreateBucketIfNotExists(visitedOrder)
+		if err != nil {
+			return err
+		}
+		if order.Sequence() < uint64(keep) {
+			return fmt.Errorf("%s holds fewer URLs than the saved crawl state has seen", path)
+		}
+
+		// Drop what was added after the state was saved
+		c := order.Cursor()
+		for k, url := c.Seek(visitedKey(uint64(keep) + 1)); k != nil; k, url = c.Next() {
+			if err := urls.Delete(url); err != nil {
+				return err
+			}
+			if err := c.Delete(); err != nil {
+				return err
+			}
+		}
+		return order.SetSequence(uint64(keep))
+	})
+	if err == nil {
+		err = db.Sync()
+	}
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &diskVisited{db: db, path: path}, nil
+}
+
+func visitedKey(n uint64) []byte {
+	var key [8]byte
+	binary.BigEndian.PutUint64(key[:], n)
+	return key[:]
+}
+
+func (s *diskVisited) Has(url string) bool {
+	if s.Err() != nil {
+		return true
+	}
+	found := false
+	err := s.db.View(func(tx *bolt.Tx) error {
+		found = tx.Bucket(visitedURLs).Get([]byte(url)) != nil
+		return nil
+	})
+	if err != nil {
+		s.fail(err)
+		return true
+	}
+	return found
+}
+
+func (s *diskVisited) Add(url string) bool {
+	if s.Err() != nil {
+		return false
+	}
+	added := false
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		urls, order := tx.Bucket(visitedURLs), tx.Bucket(visitedOrder)
+		if urls.Get([]byte(url)) != nil {
+			return nil
+		}
+		n, err := order.NextSequence()
+		if err != nil {
+			return err
+		}
+		if err := urls.Put([]byte(url), visitedKey(n)); err != nil {
+			return err
+		}
+		added = true
+		return order.Put(visitedKey(n), []byte(url))
+	})
+	if err != nil {
+		s.fail(err)
+		return false
+	}
+	return added
+}
+
+// fail records the first error of the database, which stops the crawl
+func (s *diskVisited) fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err == nil {
+		fmt.Printf("Error in visited store %s, stopping: %v\n", s.path, err)
+		s.err = err
+	}
+}
+
+func (s *diskVisited) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func (s *diskVisited) Each(fn func(url string)) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(visitedOrder).ForEach(func(_, url []byte) error {
+			fn(string(url))
+			return nil
+		})
+	})
+}
+
+// len returns the number of URLs in the set
+func (s *diskVisited) len() int {
+	var n uint64
+	s.db.View(func(tx *bolt.Tx) error {
+		n = tx.Bucket(visitedOrder).Sequence()
+		return nil
+	})
+	return int(n)
+}
+
+// checkpoint syncs the database to disk and returns the number of URLs
+// in it, for the crawl state to record
+func (s *diskVisited) checkpoint() (int64, error) {
+	if err := s.Err(); err != nil {
+		return 0, err
+	}
+	n := s.len()
+	if err := s.db.Sync(); err != nil {
+		s.fail(err)
+		return 0, err
+	}
+	return int64(n), nil
+}
+
+// Close syncs and closes the database. It stays on disk for a crawl
+// state to be resumed from.
+func (s *diskVisited) Close() error {
+	err := s.db.Sync()
+	if err2 := s.db.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// removeVisitedStore removes the database of the visited set at path
+func removeVisitedStore(path string) error {
+	return os.Remove(path)
+}
@@ -0,0 +1,158 @@
+package mirror
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func testVisitedSet(t *testing.T, s VisitedSet) {
+	if s.Has("http://example.com/") {
+		t.Fatal("Has reports a URL never added")
+	}
+	if !s.Add("http://example.com/") {
+		t.Fatal("Add reports a new URL as already there")
+	}
+	if s.Add("http://example.com/") {
+		t.Fatal("Add reports a URL added twice as new")
+	}
+	if !s.Has("http://example.com/") {
+		t.Fatal("Has doesn't report a URL added")
+	}
+	if s.Has("http://example.com") {
+		t.Fatal("Has reports a URL differing only in its path")
+	}
+}
+
+func eachURL(t *testing.T, s VisitedSet) []string {
+	var urls []string
+	if err := s.Each(func(url string) { urls = append(urls, url) }); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(urls)
+	return urls
+}
+
+func TestMemoryVisited(t *testing.T) {
+	s := memoryVisited{}
+	testVisitedSet(t, s)
+	if urls := eachURL(t, s); len(urls) != 1 || urls[0] != "http://example.com/" {
+		t.Errorf("Each = %q, want the one URL added", urls)
+	}
+}
+
+func TestDiskVisited(t *testing.T) {
+	s, err := openDiskVisited(filepath.Join(t.TempDir(), "visited"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	testVisitedSet(t, s)
+	if urls := eachURL(t, s); len(urls) != 1 || urls[0] != "http://example.com/" {
+		t.Errorf("Each = %q, want the one URL added", urls)
+	}
+}
+
+func TestDiskVisitedMany(t *testing.T) {
+	s, err := openDiskVisited(filepath.Join(t.TempDir(), "visited"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	const n = 1000
+	var want []string
+	for i := 0; i < n; i++ {
+		url := fmt.Sprintf("http://example.com/%d", i)
+		want = append(want, url)
+		if !s.Add(url) {
+			t.Fatalf("Add(%q) reports a new URL as already there", url)
+		}
+	}
+	for _, url := range want {
+		if !s.Has(url) {
+			t.Fatalf("Has(%q) = false", url)
+		}
+		if s.Add(url) {
+			t.Fatalf("Add(%q) reports a URL added twice as new", url)
+		}
+	}
+	if s.Has("http://example.com/1000") {
+		t.Error("Has reports a URL never added")
+	}
+	if s.len() != n {
+		t.Errorf("len = %d, want %d", s.len(), n)
+	}
+
+	sort.Strings(want)
+	got := eachURL(t, s)
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Each returns %d URLs, want the %d added", len(got), len(want))
+	}
+}
+
+func TestDiskVisitedFailure(t *testing.T) {
+	s, err := openDiskVisited(filepath.Join(t.TempDir(), "visited"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Add("http://example.com/a")
+	s.fail(errors.New("disk full"))
+
+	// Nothing more is taken as new, so nothing more is queued
+	if !s.Has("http://example.com/b") || s.Add("http://example.com/b") {
+		t.Error("a failed store reports a URL as new")
+	}
+	if err := s.Err(); err == nil || err.Error() != "disk full" {
+		t.Errorf("Err = %v, want the first failure", err)
+	}
+	if _, err := s.checkpoint(); err == nil {
+		t.Error("a failed store checkpoints")
+	}
+	s.Close()
+}
+
+func TestDiskVisitedReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "visited")
+	s, err := openDiskVisited(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Add("http://example.com/a")
+	keep, err := s.checkpoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Add("http://example.com/b")
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only what the checkpoint covers is kept
+	s, err = openDiskVisited(path, keep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.Has("http://example.com/a") {
+		t.Error("a URL added before the checkpoint is gone")
+	}
+	if s.Has("http://example.com/b") {
+		t.Error("a URL added after the checkpoint is kept")
+	}
+	if !s.Add("http://example.com/b") {
+		t.Error("Add reports a URL dropped with the checkpoint as already there")
+	}
+	if urls := eachURL(t, s); fmt.Sprint(urls) != "[http://example.com/a http://example.com/b]" {
+		t.Errorf("Each = %q", urls)
+	}
+	s.Close()
+
+	if _, err := openDiskVisited(path, 1<<20); err == nil {
+		t.Error("opening a store shorter than the checkpoint succeeds")
+	}
+	if err := removeVisitedStore(path); err != nil {
+		t.Error(err)
+	}
+}
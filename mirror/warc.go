@@ -0,0 +1,168 @@
+package mirror
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// warcWriter appends the requests and responses of a mirror run to a
+// WARC 1.1 file. With compression every record is its own gzip member,
+// as archiving tools expect of .warc.gz files.
+type warcWriter struct {
+	mu       sync.Mutex
+	f        *os.File
+	compress bool
+	infoID   string // Record ID of the warcinfo record heading the file
+}
+
+// newWARCWriter creates the WARC file named in config and writes its
+// warcinfo record
+func newWARCWriter(config *Config) (*warcWriter, error) {
+	f, err := os.Create(config.WARCFile)
+	if err != nil {
+		return nil, err
+	}
+	w := &warcWriter{f: f, compress: config.WARCCompress, infoID: newRecordID()}
+
+	robots := "classic"
+	if config.IgnoreRobots {
+		robots = "ignore"
+	}
+	info := "software: wget (Go)\r\nformat: WARC File Format 1.1\r\nrobots: " + robots + "\r\n"
+	fields := []warcField{
+		{"WARC-Type", "warcinfo"},
+		{"WARC-Record-ID", w.infoID},
+		{"WARC-Date", time.Now().UTC().Format(time.RFC3339)},
+		{"WARC-Filename", filepath.Base(config.WARCFile)},
+		{"Content-Type", "application/warc-fields"},
+	}
+	if err := w.writeRecord(fields, strings.NewReader(info), int64(len(info))); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// writeExchange records a request and the response to it, whose body is
+// size bytes read from body
+func (w *warcWriter) writeExchange(resp *http.Response, body io.ReadSeeker, size int64) error {
+	req := resp.Request
+	date := time.Now().UTC().Format(time.RFC3339)
+	responseID := newRecordID()
+
+	var head bytes.Buffer
+	fmt.Fprintf(&head, "HTTP/%d.%d %s\r\n", resp.ProtoMajor, resp.ProtoMinor, resp.Status)
+	resp.Header.Write(&head)
+	head.WriteString("\r\n")
+
+	// The block is the head and the body; the payload just the body
+	payload, block := sha1.New(), sha1.New()
+	block.Write(head.Bytes())
+	if _, err := io.Copy(io.MultiWriter(payload, block), body); err != nil {
+		return err
+	}
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	fields := []warcField{
+		{"WARC-Type", "response"},
+		{"WARC-Record-ID", responseID},
+		{"WARC-Warcinfo-ID", w.infoID},
+		{"WARC-Date", date},
+		{"WARC-Target-URI", req.URL.String()},
+		{"WARC-Payload-Digest", warcDigest(payload)},
+		{"WARC-Block-Digest", warcDigest(block)},
+		{"Content-Type", "application/http;msgtype=response"},
+	}
+	if err := w.writeRecord(fields, io.MultiReader(bytes.NewReader(head.Bytes()), body), int64(head.Len())+size); err != nil {
+		return err
+	}
+
+	var request bytes.Buffer
+	fmt.Fprintf(&request, "%s %s HTTP/1.1\r\nHost: %s\r\n", req.Method, req.URL.RequestURI(), req.URL.Host)
+	req.Header.Write(&request)
+	request.WriteString("\r\n")
+
+	fields = []warcField{
+		{"WARC-Type", "request"},
+		{"WARC-Record-ID", newRecordID()},
+		{"WARC-Warcinfo-ID", w.infoID},
+		{"WARC-Concurrent-To", responseID},
+		{"WARC-Date", date},
+		{"WARC-Target-URI", req.URL.String()},
+		{"Content-Type", "application/http;msgtype=request"},
+	}
+	return w.writeRecord(fields, bytes.NewReader(request.Bytes()), int64(request.Len()))
+}
+
+// warcField is one named field of a WARC record header
+type warcField struct {
+	name, value string
+}
+
+// writeRecord writes one record with the given header fields and a block
+// of size bytes read from block
+func (w *warcWriter) writeRecord(fields []warcField, block io.Reader, size int64) error {
+	var out io.Writer = w.f
+	var zw *gzip.Writer
+	if w.compress {
+		zw = gzip.NewWriter(w.f)
+		out = zw
+	}
+	bw := bufio.NewWriter(out)
+
+	bw.WriteString("WARC/1.1\r\n")
+	for _, field := range fields {
+		fmt.Fprintf(bw, "%s: %s\r\n", field.name, field.value)
+	}
+	fmt.Fprintf(bw, "Content-Length: %d\r\n\r\n", size)
+	if _, err := io.CopyN(bw, block, size); err != nil {
+		return err
+	}
+	bw.WriteString("\r\n\r\n")
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	if zw != nil {
+		return zw.Close()
+	}
+	return nil
+}
+
+// close closes the WARC file
+func (w *warcWriter) close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.f.Close()
+}
+
+// newRecordID returns a fresh random WARC-Record-ID
+func newRecordID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40 // version 4
+	b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("<urn:uuid:%x-%x-%x-%x-%x>", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// warcDigest returns the WARC form of a SHA-1 digest
+func warcDigest(h hash.Hash) string {
+	return "sha1:" + base32.StdEncoding.EncodeToString(h.Sum(nil))
+}
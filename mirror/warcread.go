@@ -0,0 +1,168 @@
+package mirror
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// WARCArchive serves the responses captured in a WARC file as if they
+// came from the network, so a mirror can be rebuilt from an archive.
+// Use it as the Transport of Config.Client. URLs missing from the
+// archive get a 404.
+type WARCArchive struct {
+	dir       string            // Holds each response captured, one file apiece
+	responses map[string]string // Response file by normalized target URL
+}
+
+// OpenWARC indexes the response records of the WARC file at path, which
+// may be gzipped. Later captures of a URL replace earlier ones.
+func OpenWARC(path string) (*WARCArchive, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	if magic, _ := r.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = bufio.NewReader(zr)
+	}
+
+	dir, err := os.MkdirTemp("", "wget-warc-")
+	if err != nil {
+		return nil, err
+	}
+	a := &WARCArchive{dir: dir, responses: map[string]string{}}
+	if err := a.index(textproto.NewReader(r)); err != nil {
+		a.Close()
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return a, nil
+}
+
+// index reads every record from r, keeping the HTTP responses
+func (a *WARCArchive) index(r *textproto.Reader) error {
+	for n := 0; ; n++ {
+		// Records end in a blank line or two; skip to the next version line
+		var version string
+		for version == "" {
+			line, err := r.ReadLine()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			version = strings.TrimSpace(line)
+		}
+		if !strings.HasPrefix(version, "WARC/") {
+			return fmt.Errorf("record %d: expected a WARC version line, got %q", n+1, version)
+		}
+
+		header, err := r.ReadMIMEHeader()
+		if err != nil {
+			return fmt.Errorf("record %d: %v", n+1, err)
+		}
+		size, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+		if err != nil {
+			return fmt.Errorf("record %d: bad Content-Length %q", n+1, header.Get("Content-Length"))
+		}
+		block := io.LimitReader(r.R, size)
+
+		target := strings.Trim(header.Get("WARC-Target-URI"), "<>")
+		if header.Get("WARC-Type") == "response" && strings.HasPrefix(header.Get("Content-Type"), "application/http") {
+			if err := a.keep(target, block, n); err != nil {
+				return err
+			}
+		}
+		if _, err := io.Copy(io.Discard, block); err != nil {
+			return err
+		}
+	}
+}
+
+// keep saves the response block of record n, captured from target
+func (a *WARCArchive) keep(target string, block io.Reader, n int) error {
+	u, err := url.Parse(target)
+	if err != nil || !u.IsAbs() {
+		return nil
+	}
+	name := filepath.Join(a.dir, strconv.Itoa(n))
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, block); err != nil {
+		return err
+	}
+	a.responses[normalizeURL(u, false).String()] = name
+	return nil
+}
+
+// RoundTrip implements http.RoundTripper, answering req from the archive
+func (a *WARCArchive) RoundTrip(req *http.Request) (*http.Response, error) {
+	name, ok := a.responses[normalizeURL(req.URL, false).String()]
+	if !ok {
+		return &http.Response{
+			Status:     "404 Not Found",
+			StatusCode: http.StatusNotFound,
+			Proto:      "HTTP/1.1",
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Header:     http.Header{},
+			Body:       http.NoBody,
+			Request:    req,
+		}, nil
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(f), req)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	body := struct {
+		io.Reader
+		io.Closer
+	}{resp.Body, f}
+
+	// Archivers keep bodies as sent; undo compression as the network
+	// transport would
+	if strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		zr, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		body.Reader = zr
+		resp.Header.Del("Content-Encoding")
+		resp.Header.Del("Content-Length")
+		resp.ContentLength = -1
+		resp.Uncompressed = true
+	}
+	resp.Body = body
+	return resp, nil
+}
+
+// Close removes the responses extracted from the archive
+func (a *WARCArchive) Close() error {
+	return os.RemoveAll(a.dir)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os/exec"
+	"runtime"
+	"time"
+)
+
+// notification is the JSON payload posted to --notify-webhook
+type notification struct {
+	URL        string `json:"url"`
+	Status     string `json:"status"`
+	File       string `json:"file,omitempty"`
+	Size       int64  `json:"size,omitempty"`
+	Error      string `json:"error,omitempty"`
+	FinishedAt string `json:"finished_at"`
+}
+
+// notifyResult reports a finished job to the configured webhook and/or
+// desktop. Notification failures are logged, never fatal.
+func notifyResult(config Config, result downloadResult) {
+	if config.notifyWebhook == "" && !config.notifyDesktop {
+		return
+	}
+
+	n := notification{
+		URL:        result.url,
+		Status:     "ok",
+		File:       result.file.path,
+		Size:       result.file.size,
+		FinishedAt: time.Now().Format(time.RFC3339),
+	}
+	if result.err != nil {
+		n.Status = "failed"
+		n.Error = result.err.Error()
+	}
+
+	if config.notifyWebhook != "" {
+		if err := postWebhook(config, n); err != nil {
+			log.Printf("notify webhook: %v\n", err)
+		}
+	}
+	if config.notifyDesktop {
+		if err := desktopNotify(n); err != nil {
+			log.Printf("desktop notification: %v\n", err)
+		}
+	}
+}
+
+// postWebhook POSTs n as JSON to the webhook URL
+func postWebhook(config Config, n notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	client := config.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequest("POST", config.notifyWebhook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// desktopNotify shows a desktop notification with notify-send on Linux
+// and osascript on macOS
+func desktopNotify(n notification) error {
+	title := "wget: download finished"
+	message := n.URL
+	if n.Status != "ok" {
+		title = "wget: download failed"
+		message = n.URL + ": " + n.Error
+	}
+
+	switch runtime.GOOS {
+	case "linux", "freebsd", "openbsd", "netbsd":
+		return exec.Command("notify-send", title, message).Run()
+	case "darwin":
+		script := fmt.Sprintf("display notification %q with title %q", message, title)
+		return exec.Command("osascript", "-e", script).Run()
+	}
+	return fmt.Errorf("not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// exitTooLarge is the exit status when the only downloads that did not
+// complete were skipped by --max-filesize
+const exitTooLarge = 3
+
+// tooLargeError reports a download skipped because of its size
+type tooLargeError struct {
+	size  int64
+	limit int64
+}
+
+func (e *tooLargeError) Error() string {
+	return fmt.Sprintf("skipped: size %s exceeds --max-filesize %s", formatSize(e.size), formatSize(e.limit))
+}
+
+func isTooLarge(err error) bool {
+	var tl *tooLargeError
+	return errors.As(err, &tl)
+}
+
+// parseSize parses a byte count with an optional k, m or g suffix
+func parseSize(s string) (int64, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if strings.HasSuffix(s, "g") {
+		n, err := parseRateLimit(strings.TrimSuffix(s, "g") + "m")
+		return n * 1024, err
+	}
+	return parseRateLimit(s)
+}
+
+func formatSize(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1fGB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1fMB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1fKB", float64(n)/(1<<10))
+	}
+	return strconv.FormatInt(n, 10) + "B"
+}
+
+// checkSize fails if a download of size bytes is over --max-filesize.
+// An unknown size (-1) is allowed through.
+func checkSize(size int64, config Config) error {
+	if config.maxBytes > 0 && size > config.maxBytes {
+		return &tooLargeError{size: size, limit: config.maxBytes}
+	}
+	return nil
+}
+
+// preflight sends a HEAD request for url to learn its size and type
+// before committing to the download. Servers that refuse HEAD are not an
+// error; the Content-Length of the GET is checked instead.
+func preflight(ctx context.Context, url string, config Config) error {
+	req, err := http.NewRequestWithContext(ctx, "HEAD", url, nil)
+	if err != nil {
+		return err
+	}
+	for name, values := range config.headers {
+		for _, v := range values {
+			req.Header.Add(name, v)
+		}
+	}
+	if config.userAgent != "" {
+		req.Header.Set("User-Agent", config.userAgent)
+	}
+
+	client := config.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "unknown"
+	}
+	size := "unknown"
+	if resp.ContentLength >= 0 {
+		size = formatSize(resp.ContentLength)
+	}
+	fmt.Printf("preflight: size %s, type %s\n", size, contentType)
+	return checkSize(resp.ContentLength, config)
+}
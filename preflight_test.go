@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		s    string
+		want int64
+	}{
+		{"512", 512},
+		{"10k", 10 << 10},
+		{"5M", 5 << 20},
+		{" 2g ", 2 << 30},
+	}
+	for _, tt := range tests {
+		got, err := parseSize(tt.s)
+		if err != nil || got != tt.want {
+			t.Errorf("parseSize(%q) = %d, %v; want %d", tt.s, got, err, tt.want)
+		}
+	}
+	if _, err := parseSize("big"); err == nil {
+		t.Error("parseSize(\"big\") succeeds")
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{999, "999B"},
+		{1536, "1.5KB"},
+		{5 << 20, "5.0MB"},
+		{3 << 30, "3.0GB"},
+	}
+	for _, tt := range tests {
+		if got := formatSize(tt.n); got != tt.want {
+			t.Errorf("formatSize(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestPreflight(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/no-head" && r.Method == "HEAD" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.Write(make([]byte, 2048))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		path     string
+		maxBytes int64
+		tooLarge bool
+	}{
+		{"/file", 0, false},
+		{"/file", 4096, false},
+		{"/file", 1024, true},
+		{"/no-head", 1024, false},
+	}
+	for _, tt := range tests {
+		config := Config{client: srv.Client(), maxBytes: tt.maxBytes}
+		err := preflight(context.Background(), srv.URL+tt.path, config)
+		if isTooLarge(err) != tt.tooLarge || (err != nil && !tt.tooLarge) {
+			t.Errorf("preflight(%s) with --max-filesize %d = %v", tt.path, tt.maxBytes, err)
+		}
+	}
+}
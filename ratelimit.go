@@ -0,0 +1,90 @@
+package main
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// bandwidthLimiter paces reads so that all transfers together stay under
+// a rate that may be changed while they are running
+type bandwidthLimiter struct {
+	mu   sync.Mutex
+	rate int64     // bytes per second; 0 means unlimited
+	next time.Time // when the next read may start
+}
+
+// bandwidth is the process-wide limit set by --rate-limit
+var bandwidth = &bandwidthLimiter{}
+
+// SetRate changes the limit; 0 removes it. Transfers pick up the new
+// rate on their next read.
+func (l *bandwidthLimiter) SetRate(rate int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rate = rate
+	l.next = time.Time{}
+}
+
+// Rate returns the current limit in bytes per second
+func (l *bandwidthLimiter) Rate() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate
+}
+
+// wait accounts for n bytes just read, sleeping as long as needed to keep
+// the overall rate under the limit
+func (l *bandwidthLimiter) wait(n int) {
+	l.mu.Lock()
+	if l.rate <= 0 || n <= 0 {
+		l.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	l.next = l.next.Add(time.Duration(float64(n) / float64(l.rate) * float64(time.Second)))
+	delay := l.next.Sub(now)
+	l.mu.Unlock()
+
+	time.Sleep(delay)
+}
+
+// maxRead caps a single read at a quarter second's worth of data so rate
+// changes take effect quickly
+func (l *bandwidthLimiter) maxRead(size int) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate <= 0 {
+		return size
+	}
+	limit := int(l.rate / 4)
+	if limit < 512 {
+		limit = 512
+	}
+	if size > limit {
+		return limit
+	}
+	return size
+}
+
+type rateLimitedReader struct {
+	r       io.Reader
+	limiter *bandwidthLimiter
+}
+
+func newRateLimitedReader(r io.Reader, limiter *bandwidthLimiter) io.Reader {
+	return &rateLimitedReader{
+		r:       r,
+		limiter: limiter,
+	}
+}
+
+func (r *rateLimitedReader) Read(p []byte) (n int, err error) {
+	p = p[:r.limiter.maxRead(len(p))]
+	n, err = r.r.Read(p)
+	r.limiter.wait(n)
+	return
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestBandwidthLimiterMaxRead(t *testing.T) {
+	tests := []struct {
+		rate int64
+		size int
+		want int
+	}{
+		{0, 32 * 1024, 32 * 1024},
+		{1024 * 1024, 32 * 1024, 32 * 1024},
+		{40 * 1024, 32 * 1024, 10 * 1024},
+		{100, 32 * 1024, 512},
+		{100, 100, 100},
+	}
+	for _, tt := range tests {
+		l := &bandwidthLimiter{}
+		l.SetRate(tt.rate)
+		if got := l.maxRead(tt.size); got != tt.want {
+			t.Errorf("rate %d: maxRead(%d) = %d, want %d", tt.rate, tt.size, got, tt.want)
+		}
+	}
+}
+
+func TestRateLimitedReader(t *testing.T) {
+	l := &bandwidthLimiter{}
+	l.SetRate(20 * 1024)
+	data := bytes.Repeat([]byte("x"), 10*1024)
+
+	start := time.Now()
+	got, err := io.ReadAll(newRateLimitedReader(bytes.NewReader(data), l))
+	if err != nil {
+		t.Fatal(err)
+	}
+	elapsed := time.Since(start)
+	if !bytes.Equal(got, data) {
+		t.Fatal("the reader changes the data")
+	}
+	// 10KiB at 20KiB/s
+	if elapsed < 400*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("reading took %v, want about half a second", elapsed)
+	}
+
+	// Lifting the limit mid-transfer takes effect at the next read
+	l.SetRate(0)
+	start = time.Now()
+	if _, err := io.ReadAll(newRateLimitedReader(bytes.NewReader(data), l)); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("unlimited reading took %v", elapsed)
+	}
+	if l.Rate() != 0 {
+		t.Errorf("Rate = %d after lifting the limit", l.Rate())
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// resumeInfo holds the validators of a partially downloaded file, kept
+// in a sidecar until the download completes. The sidecar is named after
+// the file name known before the response arrives, so it is found even
+// when a type extension was added to the file itself.
+type resumeInfo struct {
+	URL          string `json:"url"`
+	File         string `json:"file,omitempty"` // The partial file, if not the name the sidecar is keyed to
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+func resumeInfoPath(fileName string) string {
+	return fileName + ".wget-resume"
+}
+
+// saveResumeInfo records the validators of resp, and where its body is
+// saved, for a later -c of the download keyed to fileName
+func saveResumeInfo(fileName, savedAs, url string, resp *http.Response) {
+	info := resumeInfo{
+		URL:          url,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
+	if savedAs != fileName {
+		info.File = savedAs
+	}
+	if info.ETag == "" && info.LastModified == "" && info.File == "" {
+		removeResumeInfo(fileName)
+		return
+	}
+	data, err := json.Marshal(info)
+	if err != nil {
+		return
+	}
+	os.WriteFile(resumeInfoPath(fileName), data, 0644)
+}
+
+func loadResumeInfo(fileName string) (resumeInfo, bool) {
+	var info resumeInfo
+	data, err := os.ReadFile(resumeInfoPath(fileName))
+	if err != nil || json.Unmarshal(data, &info) != nil {
+		return info, false
+	}
+	return info, true
+}
+
+func removeResumeInfo(fileName string) {
+	os.Remove(resumeInfoPath(fileName))
+}
+
+// resumedFileName returns the partial file an earlier download of url
+// keyed to fileName left, which has a type extension fileName lacks if
+// one was added; fileName itself if there is no record of another
+func resumedFileName(fileName, url string) string {
+	if info, ok := loadResumeInfo(fileName); ok && info.URL == url && info.File != "" {
+		return info.File
+	}
+	return fileName
+}
+
+// setResumeHeaders asks for the rest of a partial file. If-Range makes the
+// server send the whole file instead if it changed since the partial copy
+// was made, so a stale prefix is never completed with a new tail. Weak
+// ETags cannot be used with If-Range, so Last-Modified is the fallback.
+func setResumeHeaders(req *http.Request, fileName string, offset int64) {
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+
+	info, ok := loadResumeInfo(fileName)
+	if !ok {
+		return
+	}
+	if info.ETag != "" && !strings.HasPrefix(info.ETag, "W/") {
+		req.Header.Set("If-Range", info.ETag)
+	} else if info.LastModified != "" {
+		req.Header.Set("If-Range", info.LastModified)
+	}
+}
+
+// hashExisting feeds the first n bytes of fileName to w
+func hashExisting(fileName string, n int64, w io.Writer) error {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.CopyN(w, f, n)
+	return err
+}
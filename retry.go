@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"wget/mirror"
+)
+
+// retryHostFlag collects --retry-host overrides, each written as a domain
+// followed by settings, e.g. "example.com tries=5 backoff=2s on=429,503"
+type retryHostFlag []string
+
+func (f *retryHostFlag) String() string {
+	return strings.Join(*f, "; ")
+}
+
+func (f *retryHostFlag) Set(value string) error {
+	if len(strings.Fields(value)) < 2 {
+		return fmt.Errorf("expected \"<domain> key=value...\"")
+	}
+	*f = append(*f, value)
+	return nil
+}
+
+// buildRetryRules turns the retry flags into the rules used for every
+// download. Per-host overrides start from the global policy.
+func buildRetryRules(config Config) (*mirror.RetryRules, error) {
+	base := mirror.DefaultRetryPolicy(config.tries)
+	if config.retryOn != "" {
+		codes, err := parseStatusList(config.retryOn)
+		if err != nil {
+			return nil, fmt.Errorf("--retry-on: %v", err)
+		}
+		base.RetryStatus = codes
+	}
+	if config.backoff > 0 {
+		base.InitialBackoff = config.backoff
+	}
+	if config.maxBackoff > 0 {
+		base.MaxBackoff = config.maxBackoff
+	}
+	base.AttemptTimeout = config.attemptTimeout
+
+	rules := &mirror.RetryRules{Default: base, PerHost: map[string]mirror.RetryStrategy{}}
+	for _, override := range config.retryHosts {
+		fields := strings.Fields(override)
+		policy := *base
+		for _, setting := range fields[1:] {
+			if err := setRetryOption(&policy, setting); err != nil {
+				return nil, fmt.Errorf("--retry-host %s: %v", fields[0], err)
+			}
+		}
+		rules.PerHost[strings.ToLower(fields[0])] = &policy
+	}
+	return rules, nil
+}
+
+// setRetryOption applies one key=value setting of a --retry-host override
+func setRetryOption(p *mirror.RetryPolicy, setting string) error {
+	key, value, ok := strings.Cut(setting, "=")
+	if !ok {
+		return fmt.Errorf("expected key=value, got %q", setting)
+	}
+
+	var err error
+	switch key {
+	case "tries":
+		p.Tries, err = strconv.Atoi(value)
+	case "on":
+		p.RetryStatus, err = parseStatusList(value)
+	case "backoff":
+		p.InitialBackoff, err = time.ParseDuration(value)
+	case "max-backoff":
+		p.MaxBackoff, err = time.ParseDuration(value)
+	case "multiplier":
+		p.Multiplier, err = strconv.ParseFloat(value, 64)
+	case "timeout":
+		p.AttemptTimeout, err = time.ParseDuration(value)
+	default:
+		return fmt.Errorf("unknown setting %q", key)
+	}
+	return err
+}
+
+// parseStatusList parses a comma-separated list of HTTP status codes
+func parseStatusList(list string) ([]int, error) {
+	var codes []int
+	for _, field := range strings.Split(list, ",") {
+		code, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || code < 100 || code > 599 {
+			return nil, fmt.Errorf("invalid status code %q", field)
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// urlHost returns the hostname of rawURL, or "" if it does not parse
+func urlHost(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"wget/mirror"
+)
+
+// rewriteFlag collects repeated --rewrite "regex=>replacement" rules,
+// applied in the order given
+type rewriteFlag []mirror.Rewrite
+
+func (r *rewriteFlag) String() string {
+	parts := make([]string, len(*r))
+	for i, rule := range *r {
+		parts[i] = rule.Pattern.String() + "=>" + rule.Replacement
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (r *rewriteFlag) Set(value string) error {
+	pattern, replacement, ok := strings.Cut(value, "=>")
+	if !ok {
+		return fmt.Errorf("rewrite must be \"regex=>replacement\"")
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return err
+	}
+	*r = append(*r, mirror.Rewrite{Pattern: re, Replacement: replacement})
+	return nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// rateWindow is one "HH:MM-HH:MM=rate" entry of --rate-schedule. A window
+// whose end is before its start wraps past midnight, and one whose end
+// equals its start covers the whole day.
+type rateWindow struct {
+	start, end int // minutes since midnight
+	rate       int64
+}
+
+func (w rateWindow) contains(minute int) bool {
+	if w.start == w.end {
+		return true
+	}
+	if w.start < w.end {
+		return minute >= w.start && minute < w.end
+	}
+	return minute >= w.start || minute < w.end
+}
+
+// parseRateSchedule parses a comma-separated list of windows such as
+// "08:00-18:00=500k,18:00-08:00=0"
+func parseRateSchedule(s string) ([]rateWindow, error) {
+	var windows []rateWindow
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		span, rateText, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("%q: expected HH:MM-HH:MM=rate", entry)
+		}
+		from, to, ok := strings.Cut(span, "-")
+		if !ok {
+			return nil, fmt.Errorf("%q: expected HH:MM-HH:MM=rate", entry)
+		}
+		start, err := parseClock(from)
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseClock(to)
+		if err != nil {
+			return nil, err
+		}
+		rate, err := parseRateLimit(strings.TrimSpace(rateText))
+		if err != nil {
+			return nil, fmt.Errorf("%q: invalid rate: %v", entry, err)
+		}
+		windows = append(windows, rateWindow{start: start, end: end, rate: rate})
+	}
+	return windows, nil
+}
+
+// parseClock converts "HH:MM" to minutes since midnight
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// activeWindow returns the index of the first window containing t, or -1
+func activeWindow(windows []rateWindow, t time.Time) int {
+	minute := t.Hour()*60 + t.Minute()
+	for i, w := range windows {
+		if w.contains(minute) {
+			return i
+		}
+	}
+	return -1
+}
+
+// startRateSchedule applies the windows to the shared limiter, checking
+// every minute so transfers in flight pick up the new rate. Outside every
+// window the rate falls back to fallback. The rate is only changed when
+// a new window starts, so a rate set over the control socket holds until
+// then.
+func startRateSchedule(windows []rateWindow, fallback int64) {
+	apply := func(active int) {
+		rate := fallback
+		if active >= 0 {
+			rate = windows[active].rate
+		}
+		bandwidth.SetRate(rate)
+	}
+
+	current := activeWindow(windows, time.Now())
+	apply(current)
+	go func() {
+		for now := range time.Tick(time.Minute) {
+			if active := activeWindow(windows, now); active != current {
+				current = active
+				apply(current)
+			}
+		}
+	}()
+}
+
+// parseStartAt returns the next time matching s, which is either a clock
+// time ("02:30", the next occurrence) or a full "2006-01-02 15:04" date
+func parseStartAt(s string, now time.Time) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02 15:04", s, time.Local); err == nil {
+		return t, nil
+	}
+	minute, err := parseClock(s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid start time %q (want HH:MM or YYYY-MM-DD HH:MM)", s)
+	}
+	start := time.Date(now.Year(), now.Month(), now.Day(), minute/60, minute%60, 0, 0, time.Local)
+	if !start.After(now) {
+		start = start.AddDate(0, 0, 1)
+	}
+	return start, nil
+}
+
+// waitUntil sleeps until start, noting the scheduled time in the log
+func waitUntil(start time.Time) {
+	wait := time.Until(start)
+	if wait <= 0 {
+		return
+	}
+	log.Printf("Scheduled to start at %s (in %v)\n", start.Format("2006-01-02 15:04"), wait.Round(time.Second))
+	time.Sleep(wait)
+	log.Printf("Starting scheduled run\n")
+}
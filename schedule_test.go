@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateSchedule(t *testing.T) {
+	windows, err := parseRateSchedule("08:00-18:00=500k, 22:30-06:00=2m,12:00-12:00=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		clock string
+		want  int
+	}{
+		{"07:59", 2},
+		{"08:00", 0},
+		{"17:59", 0},
+		{"18:00", 2},
+		{"22:30", 1},
+		{"00:00", 1},
+		{"05:59", 1},
+	}
+	for _, tt := range tests {
+		c, _ := time.Parse("15:04", tt.clock)
+		if got := activeWindow(windows, c); got != tt.want {
+			t.Errorf("activeWindow at %s = %d, want %d", tt.clock, got, tt.want)
+		}
+	}
+	if windows[0].rate != 500*1024 || windows[1].rate != 2*1024*1024 {
+		t.Errorf("rates = %d, %d", windows[0].rate, windows[1].rate)
+	}
+
+	if windows, _ := parseRateSchedule("08:00-09:00=1k"); activeWindow(windows, time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)) != -1 {
+		t.Error("a time outside every window is matched")
+	}
+}
+
+func TestRateScheduleErrors(t *testing.T) {
+	for _, s := range []string{
+		"08:00-18:00",
+		"08:00=500k",
+		"8am-18:00=500k",
+		"08:00-25:00=500k",
+		"08:00-18:00=fast",
+	} {
+		if _, err := parseRateSchedule(s); err == nil {
+			t.Errorf("parseRateSchedule(%q) succeeds", s)
+		}
+	}
+}
+
+func TestParseStartAt(t *testing.T) {
+	now := time.Date(2024, 3, 10, 14, 0, 0, 0, time.Local)
+	tests := []struct {
+		s    string
+		want time.Time
+	}{
+		{"15:30", time.Date(2024, 3, 10, 15, 30, 0, 0, time.Local)},
+		{"02:30", time.Date(2024, 3, 11, 2, 30, 0, 0, time.Local)},
+		{"14:00", time.Date(2024, 3, 11, 14, 0, 0, 0, time.Local)},
+		{"2024-04-01 09:15", time.Date(2024, 4, 1, 9, 15, 0, 0, time.Local)},
+	}
+	for _, tt := range tests {
+		got, err := parseStartAt(tt.s, now)
+		if err != nil {
+			t.Errorf("parseStartAt(%q): %v", tt.s, err)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("parseStartAt(%q) = %v, want %v", tt.s, got, tt.want)
+		}
+	}
+	if _, err := parseStartAt("tomorrow", now); err == nil {
+		t.Error("parseStartAt(\"tomorrow\") succeeds")
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// fontTypes fills in types Go's built-in table lacks and mirrors often
+// hold
+var fontTypes = map[string]string{
+	".woff":  "font/woff",
+	".woff2": "font/woff2",
+	".ttf":   "font/ttf",
+	".otf":   "font/otf",
+	".eot":   "application/vnd.ms-fontobject",
+}
+
+// serveCommand runs "wget serve [-addr host:port] [dir]", serving a
+// mirrored directory so it can be checked in a browser. It returns the
+// exit status.
+func serveCommand(args []string) int {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := fs.String("addr", "localhost:8000", "Address to listen on")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: wget serve [-addr host:port] [dir]\n\nServes a mirrored directory, the current one by default, over HTTP.\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	dir := "."
+	if fs.NArg() > 0 {
+		dir = fs.Arg(0)
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		fmt.Printf("Error: %s is not a directory\n", dir)
+		return 1
+	}
+
+	fmt.Printf("Serving %s on http://%s/\n", dir, *addr)
+	if err := http.ListenAndServe(*addr, mirrorServer{root: dir}); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// mirrorServer serves files laid out the way --mirror saves them
+type mirrorServer struct {
+	root string
+}
+
+func (s mirrorServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	urlPath := path.Clean("/" + r.URL.Path)
+
+	// Where the mirror may have saved the URL: with its query after '?'
+	// or, with --restrict-file-names=windows, '@'; as is; as a
+	// directory's index.html; each maybe with -E's .html added. '/' is
+	// always escaped in saved names, so a query holding one can't name
+	// a file, and must not climb out of the root.
+	var candidates []string
+	if r.URL.RawQuery != "" && !strings.Contains(r.URL.RawQuery, "/") {
+		for _, sep := range []string{"?", "@"} {
+			withQuery := urlPath + sep + r.URL.RawQuery
+			candidates = append(candidates, withQuery, withQuery+".html")
+		}
+	}
+	candidates = append(candidates, urlPath, path.Join(urlPath, "index.html"), urlPath+".html")
+
+	for _, name := range candidates {
+		full := filepath.Join(s.root, filepath.FromSlash(name))
+		info, err := os.Stat(full)
+		if err != nil {
+			continue
+		}
+		if info.IsDir() {
+			// Relative links in the index resolve against the
+			// directory only with the trailing slash
+			if !strings.HasSuffix(r.URL.Path, "/") {
+				if _, err := os.Stat(filepath.Join(full, "index.html")); err == nil {
+					target := r.URL.Path + "/"
+					if r.URL.RawQuery != "" {
+						target += "?" + r.URL.RawQuery
+					}
+					http.Redirect(w, r, target, http.StatusMovedPermanently)
+					return
+				}
+			}
+			continue
+		}
+		s.serveFile(w, r, full, name)
+		log.Printf("%s %s -> %s", r.Method, r.URL.RequestURI(), name)
+		return
+	}
+	log.Printf("%s %s -> not found", r.Method, r.URL.RequestURI())
+	http.NotFound(w, r)
+}
+
+// serveFile sends the file at full, saved as name, typed by the
+// extension before any query in its name
+func (s mirrorServer) serveFile(w http.ResponseWriter, r *http.Request, full, name string) {
+	f, err := os.Open(full)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	base := path.Base(name)
+	if i := strings.IndexAny(base, "?@"); i >= 0 {
+		base = base[:i]
+	}
+	ext := strings.ToLower(path.Ext(base))
+	contentType := fontTypes[ext]
+	if contentType == "" {
+		contentType = mime.TypeByExtension(ext)
+	}
+	if contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	// Without a type, ServeContent sniffs one from the content
+	http.ServeContent(w, r, base, info.ModTime(), f)
+}
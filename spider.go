@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// checkResult is the outcome of checking one URL in spider mode
+type checkResult struct {
+	url     string
+	status  string
+	latency time.Duration // time until the response headers arrived
+	err     error
+}
+
+// checkURL issues a HEAD request for rawURL, falling back to GET for
+// servers that do not support HEAD. The body is never saved.
+func checkURL(rawURL string, config Config) checkResult {
+	result := checkResult{url: rawURL}
+	client := config.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	for _, method := range []string{"HEAD", "GET"} {
+		req, err := http.NewRequest(method, rawURL, nil)
+		if err != nil {
+			result.err = err
+			return result
+		}
+		for name, values := range config.headers {
+			for _, v := range values {
+				req.Header.Add(name, v)
+			}
+		}
+		if config.userAgent != "" {
+			req.Header.Set("User-Agent", config.userAgent)
+		}
+
+		start := time.Now()
+		resp, err := client.Do(req)
+		result.latency = time.Since(start)
+		if err != nil {
+			result.err = err
+			return result
+		}
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+		resp.Body.Close()
+
+		result.status = resp.Status
+		if method == "HEAD" && (resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented) {
+			continue
+		}
+		if resp.StatusCode >= 400 {
+			result.err = &statusError{status: resp.Status, code: resp.StatusCode}
+		}
+		return result
+	}
+	return result
+}
+
+// spiderURLs checks every URL, then prints a broken-link report and a
+// performance report of responses slower than --slow-threshold
+func spiderURLs(urls []string, config Config) []downloadResult {
+	var checks []checkResult
+	var results []downloadResult
+	for _, rawURL := range urls {
+		c := checkURL(rawURL, config)
+		if c.err != nil && c.status == "" {
+			fmt.Printf("%-24s %8v  %s\n", "error", c.latency.Round(time.Millisecond), c.url)
+		} else {
+			fmt.Printf("%-24s %8v  %s\n", c.status, c.latency.Round(time.Millisecond), c.url)
+		}
+		checks = append(checks, c)
+		results = append(results, downloadResult{url: rawURL, err: c.err})
+	}
+
+	printBrokenLinks(checks)
+	if config.slowThreshold > 0 {
+		printSlowResponses(checks, config.slowThreshold)
+	}
+	return results
+}
+
+func printBrokenLinks(checks []checkResult) {
+	var broken []checkResult
+	for _, c := range checks {
+		if c.err != nil {
+			broken = append(broken, c)
+		}
+	}
+	if len(broken) == 0 {
+		return
+	}
+
+	fmt.Printf("\nFound %d broken links:\n", len(broken))
+	for _, c := range broken {
+		fmt.Printf("  %s (%v)\n", c.url, c.err)
+	}
+}
+
+// slowResponses returns the successful checks slower than threshold,
+// slowest first
+func slowResponses(checks []checkResult, threshold time.Duration) []checkResult {
+	var slow []checkResult
+	for _, c := range checks {
+		if c.err == nil && c.latency > threshold {
+			slow = append(slow, c)
+		}
+	}
+	sort.Slice(slow, func(i, j int) bool {
+		return slow[i].latency > slow[j].latency
+	})
+	return slow
+}
+
+func printSlowResponses(checks []checkResult, threshold time.Duration) {
+	slow := slowResponses(checks, threshold)
+	if len(slow) == 0 {
+		return
+	}
+
+	fmt.Printf("\nFound %d responses slower than %v:\n", len(slow), threshold)
+	for _, c := range slow {
+		fmt.Printf("  %8v  %s\n", c.latency.Round(time.Millisecond), c.url)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCheckURL(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ok":
+		case "/private":
+			if r.Header.Get("Authorization") != "Bearer t" {
+				w.WriteHeader(http.StatusUnauthorized)
+			}
+		case "/get-only":
+			if r.Method == "HEAD" {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		path   string
+		status string
+		broken bool
+	}{
+		{"/ok", "200 OK", false},
+		{"/get-only", "200 OK", false},
+		{"/private", "200 OK", false},
+		{"/missing", "404 Not Found", true},
+	}
+	config := Config{client: srv.Client(), headers: http.Header{"Authorization": {"Bearer t"}}}
+	for _, tt := range tests {
+		c := checkURL(srv.URL+tt.path, config)
+		if c.status != tt.status || (c.err != nil) != tt.broken {
+			t.Errorf("checkURL(%s) = %q, %v; want %q, broken %v", tt.path, c.status, c.err, tt.status, tt.broken)
+		}
+	}
+
+	if c := checkURL("http://127.0.0.1:1/", Config{client: srv.Client()}); c.err == nil || c.status != "" {
+		t.Errorf("checkURL of a closed port = %q, %v", c.status, c.err)
+	}
+}
+
+func TestSlowResponses(t *testing.T) {
+	checks := []checkResult{
+		{url: "fast", latency: 10 * time.Millisecond},
+		{url: "slow", latency: 600 * time.Millisecond},
+		{url: "slower", latency: 2 * time.Second},
+		{url: "broken", latency: 3 * time.Second, err: &statusError{status: "404 Not Found", code: 404}},
+		{url: "edge", latency: 500 * time.Millisecond},
+	}
+	slow := slowResponses(checks, 500*time.Millisecond)
+	var got []string
+	for _, c := range slow {
+		got = append(got, c.url)
+	}
+	if len(got) != 2 || got[0] != "slower" || got[1] != "slow" {
+		t.Errorf("slowResponses = %q, want [slower slow]", got)
+	}
+	if slow := slowResponses(checks, time.Hour); len(slow) != 0 {
+		t.Errorf("slowResponses with a high threshold = %d checks", len(slow))
+	}
+}
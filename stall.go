@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// stallError reports a transfer aborted by --read-timeout or --min-speed
+type stallError struct {
+	reason string
+}
+
+func (e *stallError) Error() string {
+	return "transfer stalled: " + e.reason
+}
+
+func isStall(err error) bool {
+	var se *stallError
+	return errors.As(err, &se)
+}
+
+// stallWatch tracks the bytes read from a response body and cancels the
+// request when the transfer stops or slows below the configured floor
+type stallWatch struct {
+	read        atomic.Int64
+	lastRead    atomic.Int64 // unix nanoseconds of the latest read
+	readTimeout time.Duration
+	minSpeed    int64 // bytes per second
+	window      time.Duration
+}
+
+// watchStalls returns a reader over body that is watched until ctx is
+// done. It returns body unchanged when neither limit is set.
+func watchStalls(ctx context.Context, cancel context.CancelCauseFunc, body io.Reader, config Config) io.Reader {
+	if config.readTimeout <= 0 && config.minSpeedBytes <= 0 {
+		return body
+	}
+	w := &stallWatch{
+		readTimeout: config.readTimeout,
+		minSpeed:    config.minSpeedBytes,
+		window:      config.minSpeedTime,
+	}
+	w.lastRead.Store(time.Now().UnixNano())
+	go w.run(ctx, cancel)
+	return &stallReader{r: body, watch: w}
+}
+
+func (w *stallWatch) run(ctx context.Context, cancel context.CancelCauseFunc) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	windowStart, windowBytes := time.Now(), int64(0)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			idle := now.Sub(time.Unix(0, w.lastRead.Load()))
+			if w.readTimeout > 0 && idle >= w.readTimeout {
+				cancel(&stallError{reason: fmt.Sprintf("no data for %v", idle.Round(time.Second))})
+				return
+			}
+
+			if w.minSpeed <= 0 || now.Sub(windowStart) < w.window {
+				continue
+			}
+			read := w.read.Load()
+			speed := float64(read-windowBytes) / now.Sub(windowStart).Seconds()
+			if speed < float64(w.minSpeed) {
+				cancel(&stallError{reason: fmt.Sprintf("below %.2f KiB/s for %v", float64(w.minSpeed)/1024, w.window)})
+				return
+			}
+			windowStart, windowBytes = now, read
+		}
+	}
+}
+
+type stallReader struct {
+	r     io.Reader
+	watch *stallWatch
+}
+
+func (s *stallReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	if n > 0 {
+		s.watch.read.Add(int64(n))
+		s.watch.lastRead.Store(time.Now().UnixNano())
+	}
+	return n, err
+}
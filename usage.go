@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// runUsage accumulates the resources consumed by a whole run so users can
+// size machines for large jobs
+type runUsage struct {
+	start        time.Time
+	openConns    int64
+	peakConns    int64
+	bytesWritten int64
+}
+
+var usage = &runUsage{start: time.Now()}
+
+func (u *runUsage) connOpened() {
+	n := atomic.AddInt64(&u.openConns, 1)
+	for {
+		peak := atomic.LoadInt64(&u.peakConns)
+		if n <= peak || atomic.CompareAndSwapInt64(&u.peakConns, peak, n) {
+			return
+		}
+	}
+}
+
+func (u *runUsage) connClosed() {
+	atomic.AddInt64(&u.openConns, -1)
+}
+
+func (u *runUsage) addWritten(n int64) {
+	atomic.AddInt64(&u.bytesWritten, n)
+}
+
+// usageWriter counts bytes written to disk as they are written
+type usageWriter struct{}
+
+func (usageWriter) Write(p []byte) (int, error) {
+	usage.addWritten(int64(len(p)))
+	return len(p), nil
+}
+
+// report prints the usage summary
+func (u *runUsage) report(w io.Writer) {
+	fmt.Fprintf(w, "\nresource usage:\n")
+	fmt.Fprintf(w, "  wall time:        %v\n", time.Since(u.start).Round(time.Millisecond))
+	if user, sys, maxRSS, ok := processUsage(); ok {
+		fmt.Fprintf(w, "  cpu time:         %v user, %v system\n",
+			user.Round(time.Millisecond), sys.Round(time.Millisecond))
+		fmt.Fprintf(w, "  peak memory:      %.2f MiB\n", float64(maxRSS)/(1024*1024))
+	} else {
+		fmt.Fprintf(w, "  cpu time:         unavailable on this platform\n")
+	}
+	fmt.Fprintf(w, "  peak connections: %d\n", atomic.LoadInt64(&u.peakConns))
+	fmt.Fprintf(w, "  bytes written:    %d [~%.2fMB]\n",
+		atomic.LoadInt64(&u.bytesWritten),
+		float64(atomic.LoadInt64(&u.bytesWritten))/(1024*1024))
+}
+
+// written returns the bytes written so far, counting those of a mirror
+// still running
+func (u *runUsage) written() int64 {
+	n := atomic.LoadInt64(&u.bytesWritten)
+	if m := stats.mirror.Load(); m != nil {
+		n += m.BytesWritten()
+	}
+	return n
+}
+
+// writeMetrics writes the usage so far in the Prometheus text format
+func (u *runUsage) writeMetrics(w io.Writer) {
+	metric := func(name, kind, help string, value float64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %g\n", name, help, name, kind, name, value)
+	}
+	metric("wget_wall_seconds", "gauge", "Time since the run started.", time.Since(u.start).Seconds())
+	if user, sys, maxRSS, ok := processUsage(); ok {
+		metric("wget_cpu_user_seconds_total", "counter", "User CPU time consumed.", user.Seconds())
+		metric("wget_cpu_system_seconds_total", "counter", "System CPU time consumed.", sys.Seconds())
+		metric("wget_peak_memory_bytes", "gauge", "Peak resident set size.", float64(maxRSS))
+	}
+	metric("wget_open_connections", "gauge", "Connections open now.", float64(atomic.LoadInt64(&u.openConns)))
+	metric("wget_peak_connections", "gauge", "Most connections open at once.", float64(atomic.LoadInt64(&u.peakConns)))
+	metric("wget_bytes_written_total", "counter", "Bytes written to disk.", float64(u.written()))
+}
+
+// serveMetrics serves the run's usage at /metrics on addr in the
+// background
+func serveMetrics(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		usage.writeMetrics(w)
+	})
+	go func() {
+		if err := http.Serve(ln, mux); err != nil {
+			log.Printf("metrics endpoint: %v\n", err)
+		}
+	}()
+	return nil
+}
+
+// countingConn reports its close to the usage tracker exactly once
+type countingConn struct {
+	net.Conn
+	once sync.Once
+}
+
+func (c *countingConn) Close() error {
+	c.once.Do(usage.connClosed)
+	return c.Conn.Close()
+}
+
+// countingDialContext wraps dial so that every connection it opens is
+// tracked for the peak-connections figure
+func countingDialContext(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		usage.connOpened()
+		return &countingConn{Conn: conn}, nil
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "time"
+
+// processUsage is not implemented on this platform
+func processUsage() (user, sys time.Duration, maxRSS int64, ok bool) {
+	return 0, 0, 0, false
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUsagePeakConnections(t *testing.T) {
+	defer func(u *runUsage) { usage = u }(usage)
+	usage = &runUsage{}
+
+	dial := countingDialContext(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		client, server := net.Pipe()
+		server.Close()
+		return client, nil
+	})
+
+	var conns []net.Conn
+	for i := 0; i < 3; i++ {
+		conn, err := dial(context.Background(), "tcp", "example.com:80")
+		if err != nil {
+			t.Fatal(err)
+		}
+		conns = append(conns, conn)
+	}
+	for _, conn := range conns {
+		conn.Close()
+		conn.Close() // counted once
+	}
+	if conn, err := dial(context.Background(), "tcp", "example.com:80"); err == nil {
+		conn.Close()
+	}
+
+	if usage.openConns != 0 {
+		t.Errorf("open connections = %d after closing them all", usage.openConns)
+	}
+	if usage.peakConns != 3 {
+		t.Errorf("peak connections = %d, want 3", usage.peakConns)
+	}
+}
+
+func TestUsageReport(t *testing.T) {
+	u := &runUsage{peakConns: 4, bytesWritten: 3 * 1024 * 1024}
+	var buf bytes.Buffer
+	u.report(&buf)
+	for _, want := range []string{
+		"wall time:",
+		"cpu time:",
+		"peak connections: 4\n",
+		"bytes written:    3145728 [~3.00MB]\n",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("report lacks %q:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestUsageMetrics(t *testing.T) {
+	u := &runUsage{start: time.Now(), openConns: 2, peakConns: 5, bytesWritten: 4096}
+	var buf bytes.Buffer
+	u.writeMetrics(&buf)
+	for _, want := range []string{
+		"# TYPE wget_wall_seconds gauge\n",
+		"\nwget_open_connections 2\n",
+		"\nwget_peak_connections 5\n",
+		"\nwget_bytes_written_total 4096\n",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("metrics lack %q:\n%s", want, buf.String())
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+// watchState is what a poll compares against to decide whether a URL
+// changed since the last download
+type watchState struct {
+	etag         string
+	lastModified string
+	digest       string // of the saved file, for servers without validators
+	path         string
+}
+
+// watchURLs polls urls every interval and downloads the ones that
+// changed, running --exec for each new version. It never returns.
+func watchURLs(urls []string, interval time.Duration, config Config) {
+	states := make([]watchState, len(urls))
+	for {
+		for i, rawURL := range urls {
+			changed, err := pollURL(rawURL, &states[i], config)
+			switch {
+			case err != nil:
+				log.Printf("Error checking %s: %v\n", rawURL, err)
+			case !changed:
+				fmt.Printf("%s unchanged: %s\n", time.Now().Format("2006-01-02 15:04:05"), rawURL)
+			}
+		}
+		time.Sleep(interval)
+	}
+}
+
+// pollURL downloads rawURL if it changed since state was recorded. The
+// ETag and Last-Modified of a HEAD request decide when the server sends
+// them; otherwise the file is fetched and its digest compared.
+func pollURL(rawURL string, state *watchState, config Config) (bool, error) {
+	etag, lastModified, err := headValidators(rawURL, config)
+	if err != nil {
+		return false, err
+	}
+
+	if etag != "" || lastModified != "" {
+		if state.path != "" && etag == state.etag && lastModified == state.lastModified {
+			if _, err := os.Stat(state.path); err == nil {
+				return false, nil
+			}
+		}
+		saved, err := downloadFile(rawURL, config)
+		if err != nil {
+			return false, err
+		}
+		*state = watchState{etag: etag, lastModified: lastModified, path: saved.path}
+		return true, nil
+	}
+
+	// The hook must only see new versions, so it runs after the digest
+	// comparison rather than inside downloadFile
+	hook := config.execCmd
+	config.execCmd = ""
+	saved, err := downloadFile(rawURL, config)
+	if err != nil {
+		return false, err
+	}
+	digest, err := fileDigest(saved.path)
+	if err != nil {
+		return false, err
+	}
+	if digest == state.digest {
+		return false, nil
+	}
+	*state = watchState{digest: digest, path: saved.path}
+	if hook != "" {
+		return true, runHook(hook, rawURL, saved)
+	}
+	return true, nil
+}
+
+// headValidators returns the ETag and Last-Modified headers of rawURL
+func headValidators(rawURL string, config Config) (string, string, error) {
+	req, err := http.NewRequest("HEAD", rawURL, nil)
+	if err != nil {
+		return "", "", err
+	}
+	for name, values := range config.headers {
+		for _, v := range values {
+			req.Header.Add(name, v)
+		}
+	}
+	if config.userAgent != "" {
+		req.Header.Set("User-Agent", config.userAgent)
+	}
+
+	client := config.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", "", err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		// Servers that refuse HEAD are treated as having no validators
+		return "", "", nil
+	}
+	return resp.Header.Get("ETag"), resp.Header.Get("Last-Modified"), nil
+}
+
+func fileDigest(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
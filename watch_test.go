@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"wget/mirror"
+)
+
+func TestPollURL(t *testing.T) {
+	body, etag := "v1", `"1"`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/etag" {
+			w.Header().Set("ETag", etag)
+		}
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	for _, path := range []string{"/etag", "/plain"} {
+		t.Run(path, func(t *testing.T) {
+			body, etag = "v1", `"1"`
+			config := Config{client: srv.Client(), outputDir: t.TempDir(), retry: &mirror.RetryRules{Default: mirror.DefaultRetryPolicy(1)}}
+			var state watchState
+
+			steps := []struct {
+				body, etag string
+				changed    bool
+			}{
+				{"v1", `"1"`, true},
+				{"v1", `"1"`, false},
+				{"v2", `"2"`, true},
+				{"v2", `"2"`, false},
+			}
+			for i, step := range steps {
+				body, etag = step.body, step.etag
+				changed, err := pollURL(srv.URL+path, &state, config)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if changed != step.changed {
+					t.Errorf("poll %d: changed = %v, want %v", i+1, changed, step.changed)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// wgetrcCommands maps wgetrc command names to the flags they set.
+// Command names are matched after normalizeCommand, so "limit_rate",
+// "limit-rate" and "limitrate" are all the same command. Any flag name
+// is accepted as a command as well.
+var wgetrcCommands = map[string]string{
+	"outputdocument":     "O",
+	"dirprefix":          "P",
+	"background":         "B",
+	"limitrate":          "rate-limit",
+	"input":              "i",
+	"mirror":             "mirror",
+	"reject":             "R",
+	"accept":             "A",
+	"excludedirectories": "X",
+	"includedirectories": "I",
+	"convertlinks":       "convert-links",
+	"useragent":          "U",
+	"tries":              "t",
+	"httpproxy":          "proxy",
+	"httpsproxy":         "proxy",
+	"continue":           "c",
+	"reclevel":           "l",
+	"noparent":           "no-parent",
+	"spanhosts":          "H",
+	"pagerequisites":     "page-requisites",
+	"domains":            "D",
+	"adjustextension":    "E",
+	"backupconverted":    "K",
+	"restrictfilenames":  "restrict-file-names",
+	"timestamping":       "N",
+	"deleteafter":        "delete-after",
+	"quota":              "Q",
+}
+
+// wgetrcSetting is a single "command = value" line from a wgetrc file
+type wgetrcSetting struct {
+	line    int
+	command string
+	value   string
+}
+
+// defaultWgetrcPath returns $WGETRC if set, otherwise ~/.wgetrc
+func defaultWgetrcPath() string {
+	if path := os.Getenv("WGETRC"); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".wgetrc")
+}
+
+// normalizeCommand lowercases a command name and strips '-' and '_'
+func normalizeCommand(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	name = strings.ReplaceAll(name, "_", "")
+	return strings.ReplaceAll(name, "-", "")
+}
+
+// parseWgetrc reads a wgetrc-style file. Blank lines and lines starting
+// with '#' are ignored.
+func parseWgetrc(path string) ([]wgetrcSetting, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var settings []wgetrcSetting
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		command, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected \"command = value\"", path, lineNo)
+		}
+		settings = append(settings, wgetrcSetting{
+			line:    lineNo,
+			command: strings.TrimSpace(command),
+			value:   unquoteValue(strings.TrimSpace(value)),
+		})
+	}
+	return settings, scanner.Err()
+}
+
+// unquoteValue strips one level of matching single or double quotes
+func unquoteValue(value string) string {
+	if len(value) >= 2 {
+		first, last := value[0], value[len(value)-1]
+		if (first == '"' || first == '\'') && first == last {
+			return value[1 : len(value)-1]
+		}
+	}
+	return value
+}
+
+// lookupCommand resolves a wgetrc command name to a registered flag
+func lookupCommand(fs *flag.FlagSet, command string) *flag.Flag {
+	if name, ok := wgetrcCommands[normalizeCommand(command)]; ok {
+		return fs.Lookup(name)
+	}
+	return fs.Lookup(canonicalFlag(strings.ReplaceAll(command, "_", "-")))
+}
+
+// setFlag sets f from a config-file or environment value. Booleans are
+// commonly written as on/off there, which the flag package does not accept.
+func setFlag(fs *flag.FlagSet, f *flag.Flag, value string) error {
+	switch strings.ToLower(value) {
+	case "on":
+		value = "true"
+	case "off":
+		value = "false"
+	}
+	return fs.Set(f.Name, value)
+}
+
+// loadWgetrc applies the settings in the wgetrc file at path to every
+// flag that was not given on the command line. A missing file is only an
+// error when the path was requested explicitly.
+func loadWgetrc(fs *flag.FlagSet, path string, explicit bool) error {
+	if path == "" {
+		return nil
+	}
+
+	settings, err := parseWgetrc(path)
+	if err != nil {
+		if os.IsNotExist(err) && !explicit {
+			return nil
+		}
+		return err
+	}
+
+	set := setFlags(fs)
+
+	for _, s := range settings {
+		f := lookupCommand(fs, s.command)
+		if f == nil {
+			return fmt.Errorf("%s:%d: unknown command %q", path, s.line, s.command)
+		}
+		if set[f.Name] {
+			continue
+		}
+		if err := setFlag(fs, f, s.value); err != nil {
+			return fmt.Errorf("%s:%d: invalid value for %s: %v", path, s.line, s.command, err)
+		}
+	}
+	return nil
+}
+
+// setFlags returns the names of the flags that have already been set,
+// either on the command line or by a higher-precedence config source.
+// Aliases are reported under the name of the flag they stand for.
+func setFlags(fs *flag.FlagSet) map[string]bool {
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		set[canonicalFlag(f.Name)] = true
+	})
+	return set
+}
+
+// commandFlag collects the wgetrc commands given with -e
+type commandFlag []string
+
+func (c *commandFlag) String() string {
+	return strings.Join(*c, "; ")
+}
+
+func (c *commandFlag) Set(value string) error {
+	*c = append(*c, value)
+	return nil
+}
+
+// applyCommands runs -e commands such as "robots = off". They count as
+// command-line flags, so the environment and wgetrc cannot override them.
+func applyCommands(fs *flag.FlagSet, commands []string) error {
+	for _, line := range commands {
+		command, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return fmt.Errorf("-e %q: expected \"command = value\"", line)
+		}
+		command = strings.TrimSpace(command)
+		f := lookupCommand(fs, command)
+		if f == nil {
+			return fmt.Errorf("-e %q: unknown command %q", line, command)
+		}
+		if err := setFlag(fs, f, unquoteValue(strings.TrimSpace(value))); err != nil {
+			return fmt.Errorf("-e %q: %v", line, err)
+		}
+	}
+	return nil
+}
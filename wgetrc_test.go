@@ -0,0 +1,80 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadWgetrc(t *testing.T) {
+	rc := filepath.Join(t.TempDir(), "wgetrc")
+	err := os.WriteFile(rc, []byte(`# defaults
+user_agent = "rc agent"
+tries = 5
+
+mirror = on
+limit-rate = '200k'
+`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fs := flag.NewFlagSet("wget", flag.ContinueOnError)
+	agent := fs.String("U", "", "")
+	tries := fs.Int("t", 1, "")
+	mirror := fs.Bool("mirror", false, "")
+	rate := fs.String("rate-limit", "", "")
+	if err := fs.Parse([]string{"-t", "2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := loadWgetrc(fs, rc, true); err != nil {
+		t.Fatal(err)
+	}
+	if *agent != "rc agent" {
+		t.Errorf("U = %q, want the quoted value from the file", *agent)
+	}
+	if *tries != 2 {
+		t.Errorf("t = %d, want the command line value to win", *tries)
+	}
+	if !*mirror {
+		t.Error("mirror = off, want on")
+	}
+	if *rate != "200k" {
+		t.Errorf("rate-limit = %q", *rate)
+	}
+}
+
+func TestLoadWgetrcErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		rc   string
+	}{
+		{"no equals sign", "tries 5\n"},
+		{"unknown command", "no_such_command = 1\n"},
+		{"bad value", "tries = many\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc := filepath.Join(t.TempDir(), "wgetrc")
+			if err := os.WriteFile(rc, []byte(tt.rc), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			fs := flag.NewFlagSet("wget", flag.ContinueOnError)
+			fs.Int("t", 1, "")
+			if err := loadWgetrc(fs, rc, true); err == nil {
+				t.Error("loadWgetrc succeeds")
+			}
+		})
+	}
+
+	missing := filepath.Join(t.TempDir(), "missing")
+	fs := flag.NewFlagSet("wget", flag.ContinueOnError)
+	if err := loadWgetrc(fs, missing, false); err != nil {
+		t.Errorf("a missing default wgetrc is an error: %v", err)
+	}
+	if err := loadWgetrc(fs, missing, true); err == nil {
+		t.Error("a missing wgetrc given explicitly is not an error")
+	}
+}